@@ -0,0 +1,40 @@
+package onlineconfbot
+
+import (
+	"fmt"
+)
+
+const defaultMaxIDGap = 10000
+
+// checkNotifications returns descriptions of anomalies in a BotAPI response to a request made with lastID.
+// Gaps between IDs are normal since BotAPI returns only the changes with notifications enabled,
+// only gaps larger than maxGap (/onlineconf/anomaly/max-gap) are reported, 0 disables the gap check.
+func checkNotifications(lastID, maxGap int, resp *NotificationsResponse) []string {
+	var anomalies []string
+	prevID := lastID
+	if resp.LastID < lastID {
+		anomalies = append(anomalies, fmt.Sprintf("BotAPI lastid %d is lower than the stored cursor %d, the OnlineConf database was probably reset", resp.LastID, lastID))
+		// the old cursor means nothing after a reset, the order is checked from the smallest ID of the response
+		if len(resp.Notifications) > 0 {
+			smallest := resp.Notifications[0].ID
+			for _, n := range resp.Notifications[1:] {
+				smallest = min(smallest, n.ID)
+			}
+			prevID = smallest - 1
+		}
+	}
+
+	for _, n := range resp.Notifications {
+		switch {
+		case n.ID <= prevID:
+			anomalies = append(anomalies, fmt.Sprintf("notification %d arrived after %d, notifications are out of order", n.ID, prevID))
+		case maxGap > 0 && n.ID-prevID > maxGap:
+			anomalies = append(anomalies, fmt.Sprintf("notification IDs jumped from %d to %d", prevID, n.ID))
+		}
+		if n.ID > resp.LastID {
+			anomalies = append(anomalies, fmt.Sprintf("notification %d is beyond BotAPI lastid %d", n.ID, resp.LastID))
+		}
+		prevID = max(prevID, n.ID)
+	}
+	return anomalies
+}
@@ -0,0 +1,107 @@
+package onlineconfbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func response(lastID int, ids ...int) *NotificationsResponse {
+	resp := &NotificationsResponse{LastID: lastID}
+	for _, id := range ids {
+		resp.Notifications = append(resp.Notifications, Notification{ID: id})
+	}
+	return resp
+}
+
+func TestCheckNotifications(t *testing.T) {
+	tests := []struct {
+		name   string
+		lastID int
+		maxGap int
+		resp   *NotificationsResponse
+		want   []string
+	}{
+		{
+			name:   "empty",
+			lastID: 10,
+			maxGap: 100,
+			resp:   response(10),
+		},
+		{
+			name:   "in order with small gaps",
+			lastID: 10,
+			maxGap: 100,
+			resp:   response(50, 11, 20, 50),
+		},
+		{
+			name:   "at or below the cursor",
+			lastID: 10,
+			maxGap: 100,
+			resp:   response(12, 10, 12),
+			want:   []string{"notification 10 arrived after 10, notifications are out of order"},
+		},
+		{
+			name:   "out of order",
+			lastID: 10,
+			maxGap: 100,
+			resp:   response(13, 13, 11, 12),
+			want: []string{
+				"notification 11 arrived after 13, notifications are out of order",
+				"notification 12 arrived after 13, notifications are out of order",
+			},
+		},
+		{
+			name:   "gap",
+			lastID: 10,
+			maxGap: 100,
+			resp:   response(200, 11, 200),
+			want:   []string{"notification IDs jumped from 11 to 200"},
+		},
+		{
+			name:   "gap check disabled",
+			lastID: 10,
+			maxGap: 0,
+			resp:   response(200, 200),
+		},
+		{
+			name:   "beyond lastid",
+			lastID: 10,
+			maxGap: 100,
+			resp:   response(11, 11, 12),
+			want:   []string{"notification 12 is beyond BotAPI lastid 11"},
+		},
+		{
+			name:   "reset",
+			lastID: 1000,
+			maxGap: 100,
+			resp:   response(3, 1, 2, 3),
+			want:   []string{"BotAPI lastid 3 is lower than the stored cursor 1000, the OnlineConf database was probably reset"},
+		},
+		{
+			name:   "reset without notifications",
+			lastID: 1000,
+			maxGap: 100,
+			resp:   response(0),
+			want:   []string{"BotAPI lastid 0 is lower than the stored cursor 1000, the OnlineConf database was probably reset"},
+		},
+		{
+			name:   "out of order after reset",
+			lastID: 1000,
+			maxGap: 100,
+			resp:   response(3, 2, 1, 3),
+			want: []string{
+				"BotAPI lastid 3 is lower than the stored cursor 1000, the OnlineConf database was probably reset",
+				"notification 1 arrived after 2, notifications are out of order",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkNotifications(tt.lastID, tt.maxGap, tt.resp)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkNotifications() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
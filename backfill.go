@@ -0,0 +1,138 @@
+package onlineconfbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// mtimeLayout is the format of notification mtimes and /onlineconf/backfill/since.
+const mtimeLayout = "2006-01-02 15:04:05"
+
+// backfill initializes the lastid cursor of a fresh database and returns it. Without backfill configured
+// the history is skipped, otherwise the last /onlineconf/backfill/count notifications
+// or all notifications since /onlineconf/backfill/since are delivered first, marked "(backfill)".
+// The cursor stays 0 if BotAPI has no notifications yet, all of them are new then.
+func backfill(ctx context.Context, ms []*messenger) (int, error) {
+	head, err := getNotifications(ctx, 0, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	if head.LastID == 0 {
+		return 0, nil
+	}
+
+	since, err := backfillSince()
+	if err != nil {
+		return 0, err
+	}
+	fetch := func(ctx context.Context, lastID int) (*NotificationsResponse, error) {
+		return getNotifications(ctx, lastID, 1, 0)
+	}
+	start, err := backfillStart(ctx, head.LastID, config.GetInt("/onlineconf/backfill/count", 0), config.GetInt("/onlineconf/batch-size", 100), since, fetch)
+	if err != nil {
+		return 0, err
+	}
+
+	if start < head.LastID {
+		log.Ctx(ctx).Info().Int("from", start+1).Int("to", head.LastID).Msg("backfilling notifications")
+		if err := deliverBackfill(ctx, ms, start, head.LastID, since); err != nil {
+			return 0, err
+		}
+	}
+
+	if *dryRun {
+		dryRunLastID = head.LastID
+		return head.LastID, nil
+	}
+	if err := db.AdvanceLastID(ctx, 0, head.LastID); err != nil {
+		return 0, err
+	}
+	log.Ctx(ctx).Info().Int("lastID", head.LastID).Msg("lastid initialized")
+	return head.LastID, nil
+}
+
+// backfillSince returns the parsed /onlineconf/backfill/since, zero if it is not set.
+// It is checked at startup, so a mistyped time does not select a wrong range silently.
+func backfillSince() (time.Time, error) {
+	since := config.GetString("/onlineconf/backfill/since", "")
+	if since == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(mtimeLayout, since, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid /onlineconf/backfill/since %q, use YYYY-MM-DD HH:MM:SS: %w", since, err)
+	}
+	return t, nil
+}
+
+// changedBefore reports whether the notification was changed before since.
+// Notifications with an unparsable mtime are never skipped.
+func changedBefore(notification *Notification, since time.Time) bool {
+	if since.IsZero() {
+		return false
+	}
+	mtime, err := time.ParseInLocation(mtimeLayout, notification.MTime, time.Local)
+	return err == nil && mtime.Before(since)
+}
+
+// backfillStart returns the ID after which backfilled notifications start: the last count
+// notifications or, if since is set, the notifications changed since then. fetch returns the notification following lastID.
+func backfillStart(ctx context.Context, head, count, step int, since time.Time, fetch func(ctx context.Context, lastID int) (*NotificationsResponse, error)) (int, error) {
+	if since.IsZero() {
+		return max(head-count, 0), nil
+	}
+
+	// walk back until a notification older than since is found,
+	// notifications of the last step are filtered by mtime on delivery
+	start := head
+	for start > 0 {
+		start = max(start-step, 0)
+		resp, err := fetch(ctx, start)
+		if err != nil {
+			return 0, err
+		}
+		if len(resp.Notifications) == 0 || changedBefore(&resp.Notifications[0], since) {
+			break
+		}
+	}
+	return start, nil
+}
+
+func deliverBackfill(ctx context.Context, ms []*messenger, lastID, head int, since time.Time) error {
+	notifier := newNotifiers(ms)
+	for i, ntf := range notifier {
+		ntf.label = "backfill"
+		if config.GetBool("/onlineconf/backfill/admins-only", false) && ntf.audience == nil {
+			ntf.fixedTo = ms[i].admins()
+		}
+	}
+	defer notifier.flush(ctx)
+
+	for lastID < head {
+		notifications, err := getNotifications(ctx, lastID, config.GetInt("/onlineconf/batch-size", 100), 0)
+		if err != nil {
+			return err
+		}
+		if len(notifications.Notifications) == 0 {
+			return nil
+		}
+
+		for i := range notifications.Notifications {
+			notification := &notifications.Notifications[i]
+			if notification.ID > head {
+				return nil
+			}
+			lastID = notification.ID
+			if changedBefore(notification, since) {
+				continue
+			}
+			if err := notifier.notify(ctx, notification); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package onlineconfbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// notificationsAt returns a fetch function over notifications 1..len(mtimes) with the given mtimes.
+func notificationsAt(mtimes ...string) func(context.Context, int) (*NotificationsResponse, error) {
+	return func(_ context.Context, lastID int) (*NotificationsResponse, error) {
+		resp := &NotificationsResponse{LastID: len(mtimes)}
+		if lastID < len(mtimes) {
+			resp.Notifications = []Notification{{ID: lastID + 1, MTime: mtimes[lastID]}}
+		}
+		return resp, nil
+	}
+}
+
+func TestBackfillStart(t *testing.T) {
+	day := func(d int) string { return fmt.Sprintf("2024-01-%02d 12:00:00", d) }
+	mtimes := []string{day(1), day(2), day(3), day(4), day(5), day(6), day(7), day(8), day(9), day(10)}
+	since := func(s string) time.Time {
+		parsed, err := time.ParseInLocation(mtimeLayout, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name  string
+		count int
+		step  int
+		since time.Time
+		want  int
+	}{
+		{name: "nothing", want: 10},
+		{name: "count", count: 3, want: 7},
+		{name: "count beyond history", count: 20, want: 0},
+		{name: "since in the last step", step: 3, since: since(day(9)), want: 7},
+		{name: "since several steps back", step: 3, since: since(day(5)), want: 1},
+		{name: "since with a smaller step", step: 2, since: since(day(5)), want: 2},
+		{name: "since before history", step: 3, since: since("2023-12-31 00:00:00"), want: 0},
+		{name: "since after history", step: 3, since: since("2024-02-01 00:00:00"), want: 7},
+		{name: "since takes precedence over count", count: 1, step: 5, since: since(day(3)), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := backfillStart(context.Background(), len(mtimes), tt.count, tt.step, tt.since, notificationsAt(mtimes...))
+			if err != nil {
+				t.Fatalf("backfillStart() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("backfillStart() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackfillStartError(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	fetch := func(context.Context, int) (*NotificationsResponse, error) { return nil, errFetch }
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	if _, err := backfillStart(context.Background(), 10, 0, 3, since, fetch); !errors.Is(err, errFetch) {
+		t.Errorf("backfillStart() error = %v, want %v", err, errFetch)
+	}
+}
+
+func TestChangedBefore(t *testing.T) {
+	since := time.Date(2024, 1, 5, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		mtime string
+		since time.Time
+		want  bool
+	}{
+		{mtime: "2024-01-05 11:59:59", since: since, want: true},
+		{mtime: "2024-01-05 12:00:00", since: since, want: false},
+		{mtime: "2024-01-06 00:00:00", since: since, want: false},
+		{mtime: "2024-01-01 00:00:00", since: time.Time{}, want: false},
+		{mtime: "garbage", since: since, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := changedBefore(&Notification{MTime: tt.mtime}, tt.since); got != tt.want {
+			t.Errorf("changedBefore(%q, %v) = %v, want %v", tt.mtime, tt.since, got, tt.want)
+		}
+	}
+}
@@ -2,38 +2,147 @@ package onlineconfbot
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
 )
 
 type Bot interface {
 	UpdatesProcessor(context.Context)
-	Notify(ctx context.Context, user, link, text string) error
+	Notify(ctx context.Context, user string, msg *Message) error
 	MentionLink(string) string
 	ParamLink(param, link string) string
 }
 
-type debugBot struct{}
+// SharedUpdatesProcessor is implemented by bots whose UpdatesProcessor is safe to run on every instance
+// when several instances are run (/ha/enabled), e.g. because the messenger hands each update to a single
+// connection. UpdatesProcessor of other bots is only run by the leader.
+type SharedUpdatesProcessor interface {
+	SharedUpdates() bool
+}
+
+// Broadcaster is implemented by bots which deliver every notification to their own fixed recipients,
+// e.g. webhook routes, instead of subscribed users having access to the parameter.
+// Admin alerts are sent to the recipients as well.
+type Broadcaster interface {
+	Recipients() []string
+}
+
+// MessageRenderer is implemented by bots which transform messages before sending them.
+// Dry-run mode uses it to show what exactly would be sent.
+type MessageRenderer interface {
+	Render(msg *Message) any
+}
+
+// Message is a delivery to a single recipient.
+// Bots may send Text as is or lay out Notifications themselves.
+// A message without Notifications is a service message, e.g. an alert for admins.
+type Message struct {
+	Link          string          // URL of the parameter in OnlineConf UI, empty for several notifications
+	Text          string          // notifications rendered in Style using MentionLink and ParamLink
+	Style         string          // rendering style chosen for the recipient
+	Label         string          // shown before the notifications, e.g. "backfill"
+	Notifications []*Notification // one notification or a batch of compact ones
+}
+
+func newMessage(style, label string, notifications []*Notification) *Message {
+	msg := &Message{
+		Style:         style,
+		Label:         label,
+		Notifications: notifications,
+	}
+	if len(notifications) == 1 {
+		msg.Link = notifications[0].link
+	}
+	msg.Text = msg.Format(Markdown)
+	return msg
+}
+
+// Format renders all notifications of the message using the messenger markup.
+func (msg *Message) Format(markup *Markup) string {
+	if len(msg.Notifications) == 0 {
+		return markup.Escape(msg.Text)
+	}
+
+	texts := make([]string, 0, len(msg.Notifications)+1)
+	if msg.Label != "" {
+		texts = append(texts, markup.Escape("("+msg.Label+")"))
+	}
+	for _, notification := range msg.Notifications {
+		texts = append(texts, notification.Format(msg.Style, markup))
+	}
+	return strings.Join(texts, markup.newLine())
+}
+
+// dryRunBot renders messages using the real bot and writes them as JSON lines instead of sending.
+type dryRunBot struct {
+	messenger string
+	bot       Bot
+	mu        sync.Mutex
+	out       *json.Encoder
+}
 
-var _ Bot = debugBot{}
+var _ Bot = &dryRunBot{}
+var _ ProfileProvider = &dryRunBot{}
 
-func (debugBot) UpdatesProcessor(context.Context) {
+type dryRunDelivery struct {
+	Messenger     string `json:"messenger,omitempty"`
+	User          string `json:"user"`
+	Link          string `json:"link,omitempty"`
+	Style         string `json:"style"`
+	Text          string `json:"text"`
+	Notifications []int  `json:"notifications"`
+	Rendered      any    `json:"rendered,omitempty"` // messenger-specific payload, see MessageRenderer
 }
 
-func (debugBot) Notify(_ context.Context, user, link, text string) error {
-	_, err := fmt.Printf("to: %s\n%s\n%s\n", user, link, text)
-	return err
+func newDryRunBot(messenger string, bot Bot, out io.Writer) *dryRunBot {
+	return &dryRunBot{messenger: messenger, bot: bot, out: json.NewEncoder(out)}
 }
 
-func (debugBot) MentionLink(user string) string {
-	return user
+// UpdatesProcessor does nothing, commands are handled by the real bot only.
+func (*dryRunBot) UpdatesProcessor(context.Context) {
 }
 
-func (debugBot) ParamLink(param, link string) string {
-	return param
+func (b *dryRunBot) Notify(_ context.Context, user string, msg *Message) error {
+	delivery := dryRunDelivery{
+		Messenger:     b.messenger,
+		User:          user,
+		Link:          msg.Link,
+		Style:         msg.Style,
+		Text:          msg.Text,
+		Notifications: make([]int, len(msg.Notifications)),
+	}
+	for i, notification := range msg.Notifications {
+		delivery.Notifications[i] = notification.ID
+	}
+	if renderer, ok := b.bot.(MessageRenderer); ok {
+		delivery.Rendered = renderer.Render(msg)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.out.Encode(delivery)
+}
+
+func (b *dryRunBot) MentionLink(user string) string {
+	return b.bot.MentionLink(user)
+}
+
+func (b *dryRunBot) ParamLink(param, link string) string {
+	return b.bot.ParamLink(param, link)
+}
+
+func (b *dryRunBot) AuthorProfile(ctx context.Context, user string) (*AuthorProfile, error) {
+	if provider, ok := b.bot.(ProfileProvider); ok {
+		return provider.AuthorProfile(ctx, user)
+	}
+	return nil, nil
 }
 
-func IsAdmin(user string) bool {
-	for _, u := range config.GetStrings("/user/admins", nil) {
+// IsAdmin reports whether the user is an admin of the messenger of the bot, see messenger.admins.
+func IsAdmin(bot Bot, user string) bool {
+	for _, u := range messengerOf(bot).admins() {
 		if u == user {
 			return true
 		}
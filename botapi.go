@@ -0,0 +1,111 @@
+package onlineconfbot
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBotAPITimeoutMargin = 10 // seconds added to the long polling wait time
+
+var botAPIClient struct {
+	sync.Mutex
+	settings string
+	client   *http.Client
+}
+
+// getBotAPIClient returns an HTTP client configured by /onlineconf/botapi parameters.
+// The client is rebuilt when the TLS or proxy parameters change or the certificate files are modified,
+// so rotated certificates are picked up without a restart.
+func getBotAPIClient() (*http.Client, error) {
+	caFile := config.GetString("/onlineconf/botapi/ca-file", "")
+	certFile := config.GetString("/onlineconf/botapi/cert-file", "")
+	keyFile := config.GetString("/onlineconf/botapi/key-file", "")
+	proxy := config.GetString("/onlineconf/botapi/proxy", "")
+	settings := strings.Join([]string{caFile, certFile, keyFile, proxy, modTime(caFile), modTime(certFile), modTime(keyFile)}, "\x00")
+
+	botAPIClient.Lock()
+	defer botAPIClient.Unlock()
+
+	if botAPIClient.client != nil && botAPIClient.settings == settings {
+		return botAPIClient.client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read BotAPI CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in BotAPI CA bundle " + caFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load BotAPI client certificate: %w", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse BotAPI proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if botAPIClient.client != nil {
+		botAPIClient.client.CloseIdleConnections()
+	}
+	botAPIClient.settings = settings
+	botAPIClient.client = &http.Client{Transport: transport}
+	return botAPIClient.client, nil
+}
+
+// modTime returns the modification time of the file, empty if the path is empty or the file is unavailable.
+func modTime(path string) string {
+	if path == "" {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return info.ModTime().String()
+}
+
+// setBotAPIAuth authenticates the request using the bearer token if configured, or the username and password.
+func setBotAPIAuth(req *http.Request) {
+	if token := config.GetString("/onlineconf/botapi/token", ""); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return
+	}
+
+	req.SetBasicAuth(
+		config.GetString("/onlineconf/botapi/username", commandName),
+		config.GetString("/onlineconf/botapi/password", ""),
+	)
+}
+
+// botAPITimeout is the long polling wait time plus a margin for the response to arrive.
+func botAPITimeout(wait int) time.Duration {
+	return time.Duration(wait+config.GetInt("/onlineconf/botapi/timeout-margin", defaultBotAPITimeoutMargin)) * time.Second
+}
@@ -0,0 +1,335 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSyncInterval = 60 // seconds between subscription syncs
+	smtpTimeout         = 30 * time.Second
+)
+
+// EmailBot sends notifications as multipart emails. There are no incoming messages,
+// subscriptions are taken from /email/subscribers, so the list of recipients is auditable in OnlineConf.
+type EmailBot struct {
+	config   *onlineconf.Module
+	subscr   onlineconfbot.SubscriptionStorage
+	addr     string
+	host     string
+	security string
+	from     *mail.Address
+	limiter  *onlineconfbot.RateLimiter
+}
+
+var _ onlineconfbot.Bot = &EmailBot{}
+var _ onlineconfbot.MessageRenderer = &EmailBot{}
+
+func NewEmailBot(config *onlineconf.Module, subscr onlineconfbot.SubscriptionStorage) (*EmailBot, error) {
+	host := config.GetString("/email/host", "")
+	if host == "" {
+		return nil, errors.New("please specify the SMTP server using /email/host")
+	}
+
+	from, err := mail.ParseAddress(config.GetString("/email/from", ""))
+	if err != nil {
+		return nil, fmt.Errorf("please specify the sender address using /email/from: %w", err)
+	}
+
+	security := config.GetString("/email/tls", "starttls")
+	defaultPort := "587"
+	switch security {
+	case "tls":
+		defaultPort = "465"
+	case "starttls", "none":
+	default:
+		return nil, fmt.Errorf("unknown /email/tls %q, use starttls, tls or none", security)
+	}
+
+	return &EmailBot{
+		config:   config,
+		subscr:   subscr,
+		addr:     net.JoinHostPort(host, config.GetString("/email/port", defaultPort)),
+		host:     host,
+		security: security,
+		from:     from,
+		limiter:  onlineconfbot.RateLimiterFromConfig(config, "/email"),
+	}, nil
+}
+
+// emailHTML is the HTML markup with explicit line breaks.
+var emailHTML = func() *onlineconfbot.Markup {
+	markup := *onlineconfbot.HTML
+	markup.NewLine = "<br>\n"
+	return &markup
+}()
+
+// UpdatesProcessor keeps subscriptions in sync with /email/subscribers,
+// a mapping of email addresses to "edit" or "view".
+func (bot *EmailBot) UpdatesProcessor(ctx context.Context) {
+	for {
+		if err := bot.syncSubscribers(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to sync email subscribers")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(bot.config.GetInt("/email/sync-interval", defaultSyncInterval)) * time.Second):
+		}
+	}
+}
+
+func (bot *EmailBot) syncSubscribers(ctx context.Context) error {
+	var configured map[string]string
+	if ok, err := bot.config.GetStruct("/email/subscribers", &configured); err != nil {
+		return err
+	} else if !ok {
+		return nil // subscriptions are left as they are if the parameter is missing
+	}
+
+	subscribers, err := bot.subscr.Subscribers(ctx)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool, len(subscribers))
+	for _, subscr := range subscribers {
+		current[subscr.User] = subscr.WO
+	}
+
+	for user, mode := range configured {
+		var wo bool
+		switch mode {
+		case "edit":
+			wo = true
+		case "view":
+		default:
+			log.Ctx(ctx).Warn().Str("user", user).Str("mode", mode).Msg("unknown subscription mode, use edit or view")
+			continue
+		}
+		if was, ok := current[user]; ok && was == wo {
+			continue
+		}
+		if err := bot.subscr.Subscribe(ctx, user, wo); err != nil {
+			return err
+		}
+		log.Ctx(ctx).Info().Str("user", user).Str("mode", mode).Msg("email subscription added")
+	}
+
+	for user := range current {
+		if _, ok := configured[user]; !ok {
+			if err := bot.subscr.Unsubscribe(ctx, user); err != nil {
+				return err
+			}
+			log.Ctx(ctx).Info().Str("user", user).Msg("email subscription removed")
+		}
+	}
+	return nil
+}
+
+// renderedEmail is the content of an email, see Render.
+type renderedEmail struct {
+	Subject    string `json:"subject"`
+	References string `json:"references,omitempty"`
+	Text       string `json:"text"`
+	HTML       string `json:"html"`
+}
+
+func (bot *EmailBot) Notify(ctx context.Context, user string, msg *onlineconfbot.Message) error {
+	to, err := mail.ParseAddress(user)
+	if err != nil {
+		return fmt.Errorf("invalid email address %q, check /user/domain: %w", user, err)
+	}
+
+	body, err := bot.compose(to, bot.Render(msg).(*renderedEmail))
+	if err != nil {
+		return err
+	}
+
+	return bot.limiter.Do(ctx, func() error {
+		return bot.send(ctx, to.Address, body)
+	})
+}
+
+// Render returns the subject and both bodies of the email. Emails about a single parameter reference
+// a thread root derived from its path, so mail clients group changes to the same path.
+func (bot *EmailBot) Render(msg *onlineconfbot.Message) any {
+	prefix := bot.config.GetString("/email/subject-prefix", "[OnlineConf]")
+	email := &renderedEmail{
+		Text: msg.Text,
+		HTML: "<html><body>" + msg.Format(emailHTML) + "</body></html>",
+	}
+
+	paths := make(map[string]bool)
+	for _, notification := range msg.Notifications {
+		paths[notification.Path] = true
+	}
+
+	switch {
+	case len(msg.Notifications) == 0:
+		line, _, _ := strings.Cut(msg.Text, "\n")
+		email.Subject = prefix + " " + line
+	case len(paths) == 1:
+		path := msg.Notifications[0].Path
+		email.Subject = prefix + " " + path
+		email.References = bot.threadID(path)
+	default:
+		email.Subject = prefix + " " + strconv.Itoa(len(msg.Notifications)) + " changes"
+	}
+	if msg.Label != "" {
+		email.Subject += " (" + msg.Label + ")"
+	}
+	return email
+}
+
+// threadID is a stable Message-ID of a thread root which is never sent.
+func (bot *EmailBot) threadID(path string) string {
+	hash := sha1.Sum([]byte(path))
+	return "<" + hex.EncodeToString(hash[:8]) + ".path@" + bot.fromDomain() + ">"
+}
+
+func (bot *EmailBot) fromDomain() string {
+	if _, domain, ok := strings.Cut(bot.from.Address, "@"); ok {
+		return domain
+	}
+	return "onlineconf"
+}
+
+func (bot *EmailBot) compose(to *mail.Address, email *renderedEmail) ([]byte, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	parts := multipart.NewWriter(&buf)
+
+	header := textproto.MIMEHeader{}
+	header.Set("From", bot.from.String())
+	header.Set("To", to.String())
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", email.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-ID", "<"+strconv.FormatInt(time.Now().UnixNano(), 36)+"."+hex.EncodeToString(random)+"@"+bot.fromDomain()+">")
+	if email.References != "" {
+		header.Set("In-Reply-To", email.References)
+		header.Set("References", email.References)
+	}
+	header.Set("Auto-Submitted", "auto-generated")
+	header.Set("MIME-Version", "1.0")
+	header.Set("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	for _, key := range []string{"From", "To", "Subject", "Date", "Message-ID", "In-Reply-To", "References", "Auto-Submitted", "MIME-Version", "Content-Type"} {
+		if value := header.Get(key); value != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+		}
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// send delivers the email over a new SMTP connection.
+func (bot *EmailBot) send(ctx context.Context, to string, body []byte) error {
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if bot.security == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: bot.host}}).DialContext(ctx, "tcp", bot.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", bot.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, bot.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if bot.security == "starttls" {
+		if err := client.StartTLS(&tls.Config{ServerName: bot.host}); err != nil {
+			return err
+		}
+	}
+
+	if username := bot.config.GetString("/email/username", ""); username != "" {
+		auth := smtp.PlainAuth("", username, bot.config.GetString("/email/password", ""), bot.host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(bot.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (bot *EmailBot) MentionLink(user string) string {
+	return user
+}
+
+func (bot *EmailBot) ParamLink(param, link string) string {
+	if link == "" {
+		return param
+	}
+	return param + " <" + link + ">"
+}
@@ -0,0 +1,483 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-go"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultWait = 30 // seconds of /sync long polling
+
+// MatrixBot talks to users in direct rooms over the client-server API.
+// Users are stored as localpart@server, so /user/domain and /user/map apply as with email-like logins,
+// and Matrix IDs @localpart:server are derived from them. Direct rooms are linked to users in the account table.
+type MatrixBot struct {
+	apiURL   string
+	token    string
+	userID   string
+	server   string // server name of the bot, used for users without a domain
+	config   *onlineconf.Module
+	subscr   onlineconfbot.SubscriptionStorage
+	accounts onlineconfbot.AccountStorage
+	client   *http.Client
+	limiter  *onlineconfbot.RateLimiter
+	wait     int
+	txnID    atomic.Int64
+}
+
+var _ onlineconfbot.Bot = &MatrixBot{}
+var _ onlineconfbot.MessageRenderer = &MatrixBot{}
+
+func NewMatrixBot(config *onlineconf.Module, subscr onlineconfbot.SubscriptionStorage) (*MatrixBot, error) {
+	homeserver := config.GetString("/matrix/homeserver", "")
+	if homeserver == "" {
+		return nil, errors.New("please specify the homeserver URL using /matrix/homeserver")
+	}
+	token := config.GetString("/matrix/token", "")
+	if token == "" {
+		return nil, errors.New("please specify the access token using /matrix/token")
+	}
+	userID := config.GetString("/matrix/user-id", "")
+	_, server, ok := strings.Cut(userID, ":")
+	if !strings.HasPrefix(userID, "@") || !ok {
+		return nil, errors.New("please specify the Matrix ID of the bot, e.g. @onlineconf:example.com, using /matrix/user-id")
+	}
+
+	accounts, ok := subscr.(onlineconfbot.AccountStorage)
+	if !ok {
+		return nil, errors.New("subscription storage is unable to link Matrix rooms")
+	}
+
+	wait := config.GetInt("/matrix/wait", defaultWait)
+	bot := &MatrixBot{
+		apiURL:   strings.TrimRight(homeserver, "/") + "/_matrix/client/v3",
+		token:    token,
+		userID:   userID,
+		server:   server,
+		config:   config,
+		subscr:   subscr,
+		accounts: accounts,
+		client:   &http.Client{Timeout: time.Duration(wait+10) * time.Second},
+		limiter:  onlineconfbot.RateLimiterFromConfig(config, "/matrix"),
+		wait:     wait,
+	}
+	bot.txnID.Store(time.Now().UnixNano())
+	return bot, nil
+}
+
+// matrixHTML is the markup of formatted_body, newlines are not rendered by clients.
+var matrixHTML = func() *onlineconfbot.Markup {
+	markup := *onlineconfbot.HTML
+	markup.NewLine = "<br>"
+	return &markup
+}()
+
+// matrixID converts a user to the Matrix ID, users are given as localpart@server or as Matrix IDs.
+func (bot *MatrixBot) matrixID(user string) string {
+	if strings.HasPrefix(user, "@") {
+		return user
+	}
+	localpart, server, ok := strings.Cut(user, "@")
+	if !ok {
+		server = bot.server
+	}
+	return "@" + strings.ToLower(localpart) + ":" + server
+}
+
+// account converts the Matrix ID to the user as stored in subscriptions.
+func account(matrixID string) string {
+	localpart, server, _ := strings.Cut(strings.TrimPrefix(matrixID, "@"), ":")
+	return localpart + "@" + server
+}
+
+// Matrix client-server API types
+
+type mxError struct {
+	status       int
+	ErrCode      string `json:"errcode"`
+	Message      string `json:"error"`
+	RetryAfterMs int    `json:"retry_after_ms"`
+}
+
+func (e *mxError) Error() string {
+	return fmt.Sprintf("%s: %s (status %d)", e.ErrCode, e.Message, e.status)
+}
+
+type mxEvent struct {
+	Type     string          `json:"type"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type mxSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []mxEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []mxEvent `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+}
+
+type mxMember struct {
+	Membership string `json:"membership"`
+	IsDirect   bool   `json:"is_direct"`
+}
+
+type mxMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+type mxCreateRoomRequest struct {
+	Preset   string   `json:"preset"`
+	IsDirect bool     `json:"is_direct"`
+	Invite   []string `json:"invite"`
+}
+
+// syncFilter limits /sync to messages and invites, the bot does not need presence or account data.
+const syncFilter = `{"presence":{"types":[]},"account_data":{"types":[]},` +
+	`"room":{"ephemeral":{"types":[]},"account_data":{"types":[]},"state":{"types":[]},"timeline":{"types":["m.room.message"]}}}`
+
+func (bot *MatrixBot) UpdatesProcessor(ctx context.Context) {
+	since := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		query := url.Values{"filter": {syncFilter}, "timeout": {strconv.Itoa(bot.wait * 1000)}}
+		if since != "" {
+			query.Set("since", since)
+		}
+		var resp mxSyncResponse
+		err := bot.call(ctx, http.MethodGet, "/sync?"+query.Encode(), nil, &resp)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to sync")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for roomID, room := range resp.Rooms.Invite {
+			bot.handleInvite(ctx, roomID, room.InviteState.Events)
+		}
+		// messages sent before the bot has started are not treated as commands
+		if since != "" {
+			for roomID, room := range resp.Rooms.Join {
+				for _, event := range room.Timeline.Events {
+					if event.Type == "m.room.message" && event.Sender != bot.userID {
+						bot.handleMessage(ctx, roomID, event)
+					}
+				}
+			}
+		}
+		since = resp.NextBatch
+	}
+}
+
+// handleInvite joins direct rooms and links them to the inviting user, other invites are rejected.
+func (bot *MatrixBot) handleInvite(ctx context.Context, roomID string, events []mxEvent) {
+	var inviter string
+	var direct bool
+	for _, event := range events {
+		if event.Type != "m.room.member" || event.StateKey == nil || *event.StateKey != bot.userID {
+			continue
+		}
+		var member mxMember
+		if err := json.Unmarshal(event.Content, &member); err == nil && member.Membership == "invite" {
+			inviter = event.Sender
+			direct = member.IsDirect
+		}
+	}
+
+	if inviter == "" || !direct {
+		if err := bot.call(ctx, http.MethodPost, "/rooms/"+url.PathEscape(roomID)+"/leave", struct{}{}, nil); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("room", roomID).Msg("failed to reject invite")
+		}
+		return
+	}
+
+	if err := bot.call(ctx, http.MethodPost, "/rooms/"+url.PathEscape(roomID)+"/join", struct{}{}, nil); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("room", roomID).Msg("failed to join room")
+		return
+	}
+	user := account(inviter)
+	if err := bot.accounts.LinkAccount(ctx, user, roomID); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("user", user).Str("room", roomID).Msg("failed to link room")
+		return
+	}
+	log.Ctx(ctx).Info().Str("user", user).Str("room", roomID).Msg("matrix room linked")
+
+	if err := bot.sendHelp(ctx, roomID, user); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("room", roomID).Msg("failed to send help")
+	}
+}
+
+// handleMessage handles commands sent to the direct room linked to the sender, messages in other rooms are ignored.
+func (bot *MatrixBot) handleMessage(ctx context.Context, roomID string, event mxEvent) {
+	var content mxMessage
+	if err := json.Unmarshal(event.Content, &content); err != nil || content.MsgType != "m.text" {
+		return
+	}
+	fields := strings.Fields(strings.TrimLeft(strings.TrimSpace(content.Body), "!/"))
+	if len(fields) == 0 {
+		return
+	}
+	cmd := strings.ToLower(fields[0])
+	args := fields[1:]
+
+	user := account(event.Sender)
+	linked, err := bot.accounts.AccountAddress(ctx, user)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("user", user).Msg("failed to find linked room")
+		return
+	}
+	if linked != roomID {
+		return
+	}
+
+	switch cmd {
+	case "subscribe":
+		switch {
+		case len(args) == 1 && args[0] == "edit":
+			err = bot.subscribe(ctx, roomID, user, true)
+		case len(args) == 1 && args[0] == "view":
+			err = bot.subscribe(ctx, roomID, user, false)
+		default:
+			err = bot.sendText(ctx, roomID, "Usage: subscribe edit|view")
+		}
+	case "unsubscribe":
+		if err = bot.subscr.Unsubscribe(ctx, user); err == nil {
+			err = bot.sendText(ctx, roomID, "You unsubscribed")
+		}
+	case "subscribers", "failed", "replay":
+		if onlineconfbot.IsAdmin(bot, user) {
+			err = bot.handleAdminCommand(ctx, roomID, user, cmd, args)
+		} else {
+			log.Ctx(ctx).Warn().Str("user", user).Str("command", cmd).Msg("non-admin attempted admin command")
+		}
+	case "help":
+		err = bot.sendHelp(ctx, roomID, user)
+	default:
+		err = bot.sendText(ctx, roomID, "Unknown command. Use help to see available commands.")
+	}
+
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("command", cmd).Str("user", user).Msg("failed to handle command")
+	}
+}
+
+func (bot *MatrixBot) subscribe(ctx context.Context, roomID, user string, wo bool) error {
+	if err := bot.subscr.Subscribe(ctx, user, wo); err != nil {
+		return err
+	}
+	which := "view"
+	if wo {
+		which = "edit"
+	}
+	return bot.sendText(ctx, roomID, "You subscribed to parameters you can "+which)
+}
+
+func (bot *MatrixBot) handleAdminCommand(ctx context.Context, roomID, user, cmd string, args []string) error {
+	switch cmd {
+	case "subscribers":
+		subscribers, err := bot.subscr.Subscribers(ctx)
+		if err != nil {
+			return err
+		}
+		text := strings.Builder{}
+		text.WriteString("Subscribers:\n")
+		for _, subscr := range subscribers {
+			text.WriteString(subscr.User)
+			if subscr.WO {
+				text.WriteString(" - edit\n")
+			} else {
+				text.WriteString(" - view\n")
+			}
+		}
+		return bot.sendText(ctx, roomID, text.String())
+	case "failed":
+		reply, err := onlineconfbot.FailedCommand(ctx, args...)
+		if err != nil {
+			return err
+		}
+		return bot.sendText(ctx, roomID, reply)
+	case "replay":
+		return bot.sendText(ctx, roomID, onlineconfbot.ReplayCommand(ctx, bot, user, args...))
+	}
+	return nil
+}
+
+func (bot *MatrixBot) sendHelp(ctx context.Context, roomID, user string) error {
+	text := "Available commands:\n" +
+		"subscribe edit|view - Subscribe to notifications about parameters you can edit or view\n" +
+		"unsubscribe - Unsubscribe from notifications\n" +
+		"help - Show this help"
+	if onlineconfbot.IsAdmin(bot, user) {
+		text += "\nsubscribers - Show subscribed users (admin only)"
+		text += "\nfailed list|fallback|retry <id|all>|drop <id|all> - Show, retry or drop failed deliveries, show fallback deliveries (admin only)"
+		text += "\nreplay <from-id> [<to-id>] [<user>] - Send notifications again (admin only)"
+	}
+	return bot.sendText(ctx, roomID, text)
+}
+
+// Notify sends the message to the direct room of the user, the room is created on the first message.
+// A room the user has left is unlinked, so the next attempt creates a new one.
+func (bot *MatrixBot) Notify(ctx context.Context, user string, msg *onlineconfbot.Message) error {
+	roomID, err := bot.accounts.AccountAddress(ctx, user)
+	if err != nil {
+		return err
+	}
+	if roomID == "" {
+		if roomID, err = bot.createRoom(ctx, user); err != nil {
+			return err
+		}
+	}
+
+	err = bot.send(ctx, roomID, bot.Render(msg).(*mxMessage))
+	var mxErr *mxError
+	if errors.As(err, &mxErr) && mxErr.ErrCode == "M_FORBIDDEN" {
+		if unlinkErr := bot.accounts.UnlinkAccount(ctx, user); unlinkErr != nil {
+			log.Ctx(ctx).Error().Err(unlinkErr).Str("user", user).Msg("failed to unlink room")
+		}
+	}
+	return err
+}
+
+func (bot *MatrixBot) createRoom(ctx context.Context, user string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := bot.limiter.Do(ctx, func() error {
+		return bot.call(ctx, http.MethodPost, "/createRoom", mxCreateRoomRequest{
+			Preset:   "trusted_private_chat",
+			IsDirect: true,
+			Invite:   []string{bot.matrixID(user)},
+		}, &resp)
+	})
+	if err != nil {
+		return "", fmt.Errorf("create room for %s: %w", user, err)
+	}
+	if err := bot.accounts.LinkAccount(ctx, user, resp.RoomID); err != nil {
+		return "", err
+	}
+	log.Ctx(ctx).Info().Str("user", user).Str("room", resp.RoomID).Msg("matrix room created")
+	return resp.RoomID, nil
+}
+
+// Render returns the m.room.message content with the notifications in formatted_body.
+func (bot *MatrixBot) Render(msg *onlineconfbot.Message) any {
+	return &mxMessage{
+		MsgType:       "m.notice",
+		Body:          msg.Text,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: msg.Format(matrixHTML),
+	}
+}
+
+// MentionLink returns the Matrix ID, it is inserted into both body and formatted_body as is,
+// clients linkify it themselves.
+func (bot *MatrixBot) MentionLink(user string) string {
+	return html.EscapeString(bot.matrixID(user))
+}
+
+// ParamLink returns the parameter link of the plain body, formatted_body links are rendered by matrixHTML.
+func (bot *MatrixBot) ParamLink(param, link string) string {
+	if link == "" {
+		return param
+	}
+	return param + " <" + link + ">"
+}
+
+// HTTP helpers
+
+func (bot *MatrixBot) sendText(ctx context.Context, roomID, text string) error {
+	return bot.send(ctx, roomID, &mxMessage{MsgType: "m.notice", Body: text})
+}
+
+func (bot *MatrixBot) send(ctx context.Context, roomID string, content *mxMessage) error {
+	txnID := strconv.FormatInt(bot.txnID.Add(1), 36)
+	return bot.limiter.Do(ctx, func() error {
+		// the same transaction ID is reused on retries, so the homeserver deduplicates them
+		return bot.call(ctx, http.MethodPut, "/rooms/"+url.PathEscape(roomID)+"/send/m.room.message/"+txnID, content, nil)
+	})
+}
+
+// call calls the API endpoint and decodes its response into result unless it is nil.
+func (bot *MatrixBot) call(ctx context.Context, method, endpoint string, req, result any) error {
+	var body *bytes.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("marshal %s request: %w", endpoint, err)
+		}
+		body = bytes.NewReader(data)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, bot.apiURL+endpoint, body)
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", endpoint, err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+bot.token)
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := bot.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%s %s request: %w", method, strings.SplitN(endpoint, "?", 2)[0], err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		mxErr := &mxError{status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(mxErr)
+		if resp.StatusCode == http.StatusTooManyRequests || mxErr.ErrCode == "M_LIMIT_EXCEEDED" {
+			retryAfter := time.Duration(mxErr.RetryAfterMs) * time.Millisecond
+			if retryAfter == 0 {
+				retryAfter = onlineconfbot.ParseRetryAfter(resp.Header.Get("Retry-After"))
+			}
+			return &onlineconfbot.RateLimitedError{RetryAfter: retryAfter, Err: mxErr}
+		}
+		return mxErr
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("decode %s response: %w", strings.SplitN(endpoint, "?", 2)[0], err)
+		}
+	}
+	return nil
+}
@@ -1,10 +1,11 @@
-package main
+package mattermost
 
 import (
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -21,9 +22,12 @@ type MattermostBot struct {
 	commands []mmCommandHandler
 	botID    string
 	subscr   onlineconfbot.SubscriptionStorage
+	limiter  *onlineconfbot.RateLimiter
 }
 
 var _ onlineconfbot.Bot = &MattermostBot{}
+var _ onlineconfbot.ProfileProvider = &MattermostBot{}
+var _ onlineconfbot.MessageRenderer = &MattermostBot{}
 
 type mmCommandHandler struct {
 	cmd   string
@@ -32,7 +36,7 @@ type mmCommandHandler struct {
 	color string
 	// rootID is optional, userName should be taken from the GetUser API response. never use userName from message metadata.
 	handler   func(mmb *MattermostBot, ctx context.Context, channelID, rootID, userID, userName string, args ...string) error
-	isAllowed func(userName string) bool // nil - command is allowed for everyone
+	isAllowed func(bot onlineconfbot.Bot, userName string) bool // nil - command is allowed for everyone
 }
 
 var mmCommands = []mmCommandHandler{
@@ -61,6 +65,20 @@ var mmCommands = []mmCommandHandler{
 		handler:   (*MattermostBot).listSubscribers,
 		isAllowed: onlineconfbot.IsAdmin,
 	},
+	{
+		cmd:       "failed",
+		args:      "`list`|`fallback`|`retry` <id|`all`>|`drop` <id|`all`>",
+		descr:     "Show, retry or drop deliveries which failed after all attempts, show deliveries sent through the fallback messenger",
+		handler:   (*MattermostBot).failed,
+		isAllowed: onlineconfbot.IsAdmin,
+	},
+	{
+		cmd:       "replay",
+		args:      "<from-id> [<to-id>] [<user>]",
+		descr:     "Send notifications again to their subscribers or only to the given user",
+		handler:   (*MattermostBot).replay,
+		isAllowed: onlineconfbot.IsAdmin,
+	},
 }
 
 var mmCommandsByName = func() map[string]mmCommandHandler {
@@ -108,6 +126,7 @@ func NewMattermostBot(config *onlineconf.Module, subscr onlineconfbot.Subscripti
 		commands: mmCommands,
 		botID:    me.Id,
 		subscr:   subscr,
+		limiter:  onlineconfbot.RateLimiterFromConfig(config, "/mattermost"),
 	}, nil
 }
 
@@ -194,7 +213,11 @@ func (mmb *MattermostBot) handlePost(ctx context.Context, event *mm.WebSocketEve
 		}
 	}
 
-	user, _, err := mmb.api.GetUser(in.UserId, "")
+	var user *mm.User
+	err = mmb.limited(ctx, func() (resp *mm.Response, err error) {
+		user, resp, err = mmb.api.GetUser(in.UserId, "")
+		return resp, err
+	})
 	if err != nil {
 		return err
 	}
@@ -206,11 +229,11 @@ func (mmb *MattermostBot) handlePost(ctx context.Context, event *mm.WebSocketEve
 
 	handler, ok := mmCommandsByName[cmd[0]]
 	if ok && handler.isAllowed != nil {
-		ok = handler.isAllowed(user.Username)
+		ok = handler.isAllowed(mmb, user.Username)
 	}
 
 	if !ok {
-		if err := mmb.send(in.ChannelId, in.RootId, in.UserId, "⚠️ Unknown command: `"+cmd[0]+"`"); err != nil {
+		if err := mmb.send(ctx, in.ChannelId, in.RootId, in.UserId, "⚠️ Unknown command: `"+cmd[0]+"`"); err != nil {
 			return err
 		}
 
@@ -219,7 +242,7 @@ func (mmb *MattermostBot) handlePost(ctx context.Context, event *mm.WebSocketEve
 
 	err = handler.handler(mmb, ctx, in.ChannelId, in.RootId, in.UserId, user.Username, cmd[1:]...)
 	if err != nil {
-		_ = mmb.send(in.ChannelId, in.RootId, in.UserId, "⛔ Internal error")
+		_ = mmb.send(ctx, in.ChannelId, in.RootId, in.UserId, "⛔ Internal error")
 	}
 
 	return err
@@ -279,13 +302,12 @@ func (mmb *MattermostBot) sendHelp(ctx context.Context, chID, rootID, userID, us
 
 	out.AddProp("attachments", attachments)
 
-	_, _, err := mmb.api.CreatePost(out)
-	return err
+	return mmb.createPost(ctx, out)
 }
 
 func (mmb *MattermostBot) subscribe(ctx context.Context, channelID, rootID, userID, userName string, args ...string) error {
 	if len(args) != 1 {
-		return mmb.send(channelID, rootID, userID, "⚠️ subscribe command takes exactly one argument ([`view`|`edit`])")
+		return mmb.send(ctx, channelID, rootID, userID, "⚠️ subscribe command takes exactly one argument ([`view`|`edit`])")
 	}
 
 	canWrite := false
@@ -294,14 +316,14 @@ func (mmb *MattermostBot) subscribe(ctx context.Context, channelID, rootID, user
 	case "edit":
 		canWrite = true
 	default:
-		return mmb.send(channelID, rootID, userID, "⚠️ subscribe: invalid subscription mode (use `view` or `edit`)")
+		return mmb.send(ctx, channelID, rootID, userID, "⚠️ subscribe: invalid subscription mode (use `view` or `edit`)")
 	}
 
 	if err := mmb.subscr.Subscribe(ctx, userName, canWrite); err != nil {
 		return err
 	}
 
-	return mmb.send(channelID, rootID, userID, "✅ You have subscribed to parameters you can `"+args[0]+"`")
+	return mmb.send(ctx, channelID, rootID, userID, "✅ You have subscribed to parameters you can `"+args[0]+"`")
 }
 
 func (mmb *MattermostBot) unsubscribe(ctx context.Context, channelID, rootID, userID, userName string, args ...string) error {
@@ -310,7 +332,7 @@ func (mmb *MattermostBot) unsubscribe(ctx context.Context, channelID, rootID, us
 		return err
 	}
 
-	return mmb.send(channelID, rootID, userID, "❌️ You have unsubscribed")
+	return mmb.send(ctx, channelID, rootID, userID, "❌️ You have unsubscribed")
 }
 
 func (mmb *MattermostBot) listSubscribers(ctx context.Context, channelID, rootID, userID, userName string, args ...string) error {
@@ -333,31 +355,162 @@ func (mmb *MattermostBot) listSubscribers(ctx context.Context, channelID, rootID
 		}
 	}
 
-	return mmb.send(channelID, rootID, userID, resp.String())
+	return mmb.send(ctx, channelID, rootID, userID, resp.String())
+}
+
+func (mmb *MattermostBot) failed(ctx context.Context, channelID, rootID, userID, userName string, args ...string) error {
+	reply, err := onlineconfbot.FailedCommand(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	return mmb.send(ctx, channelID, rootID, userID, "```\n"+reply+"\n```")
+}
+
+func (mmb *MattermostBot) replay(ctx context.Context, channelID, rootID, userID, userName string, args ...string) error {
+	return mmb.send(ctx, channelID, rootID, userID, onlineconfbot.ReplayCommand(ctx, mmb, userName, args...))
 }
 
-func (mmb *MattermostBot) send(channelID, rootID, userID, message string) error {
-	_, _, err := mmb.api.CreatePost(&mm.Post{
+func (mmb *MattermostBot) send(ctx context.Context, channelID, rootID, userID, message string) error {
+	return mmb.createPost(ctx, &mm.Post{
 		ChannelId: channelID,
 		RootId:    rootID,
 		UserId:    userID,
 		Message:   message,
 	})
-	return err
 }
 
-func (mmb *MattermostBot) Notify(ctx context.Context, userName, link, text string) error {
-	user, _, err := mmb.api.GetUserByUsername(userName, "")
+// limited paces API calls and repeats them when the server responds with 429 Too Many Requests.
+func (mmb *MattermostBot) limited(ctx context.Context, call func() (*mm.Response, error)) error {
+	return mmb.limiter.Do(ctx, func() error {
+		resp, err := call()
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			return &onlineconfbot.RateLimitedError{
+				RetryAfter: onlineconfbot.ParseRetryAfter(resp.Header.Get("Retry-After")),
+				Err:        err,
+			}
+		}
+		return err
+	})
+}
+
+func (mmb *MattermostBot) createPost(ctx context.Context, post *mm.Post) error {
+	return mmb.limited(ctx, func() (*mm.Response, error) {
+		_, resp, err := mmb.api.CreatePost(post)
+		return resp, err
+	})
+}
+
+func (mmb *MattermostBot) Notify(ctx context.Context, userName string, msg *onlineconfbot.Message) error {
+	var user *mm.User
+	err := mmb.limited(ctx, func() (resp *mm.Response, err error) {
+		user, resp, err = mmb.api.GetUserByUsername(userName, "")
+		return resp, err
+	})
 	if err != nil {
 		return err
 	}
 
-	ch, _, err := mmb.api.CreateDirectChannel(user.Id, mmb.botID)
+	var ch *mm.Channel
+	err = mmb.limited(ctx, func() (resp *mm.Response, err error) {
+		ch, resp, err = mmb.api.CreateDirectChannel(user.Id, mmb.botID)
+		return resp, err
+	})
 	if err != nil {
 		return err
 	}
 
-	return mmb.send(ch.Id, "", user.Id, "***\n"+text)
+	post := mmb.newPost(msg)
+	post.ChannelId = ch.Id
+	post.UserId = user.Id
+	return mmb.createPost(ctx, post)
+}
+
+func (mmb *MattermostBot) Render(msg *onlineconfbot.Message) any {
+	return mmb.newPost(msg)
+}
+
+func (mmb *MattermostBot) newPost(msg *onlineconfbot.Message) *mm.Post {
+	// compact notifications are meant to be a dense list, cards would defeat the purpose
+	if onlineconfbot.IsCompact(msg.Style) || len(msg.Notifications) == 0 {
+		return &mm.Post{Message: "***\n" + msg.Text}
+	}
+
+	post := &mm.Post{}
+	if msg.Label != "" {
+		post.Message = "*(" + msg.Label + ")*"
+	}
+
+	attachments := make([]*mm.SlackAttachment, len(msg.Notifications))
+	paths := make([]string, len(msg.Notifications))
+	for i, notification := range msg.Notifications {
+		attachments[i] = notificationAttachment(notification, msg.Style)
+		paths[i] = notification.Path
+	}
+
+	post.AddProp("attachments", attachments)
+	post.AddProp("onlineconf_paths", paths)
+	if len(msg.Notifications) == 1 {
+		notification := msg.Notifications[0]
+		post.AddProp("onlineconf_id", notification.ID)
+		post.AddProp("onlineconf_path", notification.Path)
+		post.AddProp("onlineconf_action", notification.Action)
+		post.AddProp("onlineconf_author", notification.Author)
+	}
+
+	return post
+}
+
+var actionColors = map[string]string{
+	"delete": "#db0707",
+	"create": "#008000",
+	"modify": "#f5b642",
+}
+
+func notificationAttachment(notification *onlineconfbot.Notification, style string) *mm.SlackAttachment {
+	attachment := &mm.SlackAttachment{
+		Fallback:   notification.Text(style),
+		Color:      actionColors[notification.Action],
+		Pretext:    notification.MTime,
+		AuthorName: notification.AuthorMention(),
+		Title:      notification.Path,
+		TitleLink:  notification.Link(),
+		Text:       notification.ValueText(style),
+		Footer:     notification.Comment,
+		Fields: []*mm.SlackAttachmentField{
+			{Title: "Author", Value: notification.AuthorMention(), Short: true},
+			{Title: "Version", Value: strconv.Itoa(notification.Version), Short: true},
+		},
+	}
+
+	if profile := notification.AuthorProfile(); profile != nil {
+		if profile.DisplayName != "" {
+			attachment.AuthorName = profile.DisplayName
+		}
+		attachment.AuthorIcon = profile.IconURL
+	}
+
+	if notification.Action != "delete" && notification.ContentType != "" {
+		attachment.Fields = append(attachment.Fields, &mm.SlackAttachmentField{Title: "Type", Value: notification.ContentType, Short: true})
+	}
+
+	return attachment
+}
+
+func (mmb *MattermostBot) AuthorProfile(ctx context.Context, userName string) (*onlineconfbot.AuthorProfile, error) {
+	var user *mm.User
+	err := mmb.limited(ctx, func() (resp *mm.Response, err error) {
+		user, resp, err = mmb.api.GetUserByUsername(userName, "")
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &onlineconfbot.AuthorProfile{
+		DisplayName: user.GetFullName(),
+		IconURL:     mmb.api.APIURL + "/users/" + user.Id + "/image",
+	}, nil
 }
 
 func (mmb *MattermostBot) MentionLink(user string) string {
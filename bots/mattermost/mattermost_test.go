@@ -0,0 +1,128 @@
+package mattermost
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mm "github.com/mattermost/mattermost-server/v6/model"
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+)
+
+func TestNotificationAttachment(t *testing.T) {
+	value := onlineconfbot.NullString{NullString: sql.NullString{String: `{"a":1}`, Valid: true}}
+	tests := []struct {
+		name         string
+		notification *onlineconfbot.Notification
+		wantColor    string
+		wantFields   []string
+	}{
+		{
+			name: "modify",
+			notification: &onlineconfbot.Notification{
+				Path: "/app/db", Version: 3, ContentType: "application/json", Value: value,
+				MTime: "2024-01-05 12:00:00", Comment: "moved", Action: "modify", Notification: "with-value",
+			},
+			wantColor:  "#f5b642",
+			wantFields: []string{"Author", "Version=3", "Type=application/json"},
+		},
+		{
+			name: "delete",
+			notification: &onlineconfbot.Notification{
+				Path: "/app/db", Version: 4, ContentType: "application/json", Value: value,
+				MTime: "2024-01-05 12:00:00", Action: "delete", Notification: "with-value",
+			},
+			wantColor:  "#db0707",
+			wantFields: []string{"Author", "Version=4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := notificationAttachment(tt.notification, onlineconfbot.StyleEmoji)
+			if a.Color != tt.wantColor {
+				t.Errorf("Color = %q, want %q", a.Color, tt.wantColor)
+			}
+			if a.Title != tt.notification.Path || a.Pretext != tt.notification.MTime || a.Footer != tt.notification.Comment {
+				t.Errorf("Title, Pretext, Footer = %q, %q, %q", a.Title, a.Pretext, a.Footer)
+			}
+			if a.Fallback != tt.notification.Text(onlineconfbot.StyleEmoji) {
+				t.Errorf("Fallback = %q, want the notification text", a.Fallback)
+			}
+			if a.AuthorIcon != "" {
+				t.Errorf("AuthorIcon = %q without a profile", a.AuthorIcon)
+			}
+
+			if len(a.Fields) != len(tt.wantFields) {
+				t.Fatalf("got %d fields, want %q", len(a.Fields), tt.wantFields)
+			}
+			for i, f := range a.Fields {
+				got := f.Title
+				if f.Title != "Author" {
+					got += "=" + f.Value.(string)
+				}
+				if got != tt.wantFields[i] || !f.Short {
+					t.Errorf("field %d = %s (short %v), want %s", i, got, f.Short, tt.wantFields[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewPost(t *testing.T) {
+	mmb := &MattermostBot{}
+	notification := &onlineconfbot.Notification{ID: 10, Path: "/app/db", Action: "modify", Author: "alice"}
+
+	post := mmb.newPost(&onlineconfbot.Message{Text: "compact", Style: onlineconfbot.StyleCompact, Notifications: []*onlineconfbot.Notification{notification}})
+	if post.Message != "***\ncompact" || post.GetProp("attachments") != nil {
+		t.Errorf("compact post = %q with attachments %v", post.Message, post.GetProp("attachments"))
+	}
+
+	post = mmb.newPost(&onlineconfbot.Message{Label: "replay", Notifications: []*onlineconfbot.Notification{notification}})
+	if post.Message != "*(replay)*" {
+		t.Errorf("Message = %q, want the label", post.Message)
+	}
+	if attachments, _ := post.GetProp("attachments").([]*mm.SlackAttachment); len(attachments) != 1 {
+		t.Errorf("attachments = %v, want one", post.GetProp("attachments"))
+	}
+	if post.GetProp("onlineconf_id") != 10 || post.GetProp("onlineconf_author") != "alice" {
+		t.Errorf("props = %v", post.GetProps())
+	}
+}
+
+func TestAuthorProfile(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/api/v4/users/username/alice" {
+			http.NotFound(w, r)
+			return
+		}
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&mm.User{Id: "u1", Username: "alice", FirstName: "Alice", LastName: "Smith"})
+	}))
+	defer server.Close()
+
+	mmb := &MattermostBot{api: mm.NewAPIv4Client(server.URL), limiter: onlineconfbot.NewRateLimiter(0, 1)}
+	profile, err := mmb.AuthorProfile(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.DisplayName != "Alice Smith" || profile.IconURL != server.URL+"/api/v4/users/u1/image" {
+		t.Errorf("AuthorProfile() = %+v", profile)
+	}
+	if requests != 2 {
+		t.Errorf("AuthorProfile() made %d requests, want 2 after a rate-limited one", requests)
+	}
+
+	if _, err := mmb.AuthorProfile(context.Background(), "bob"); err == nil {
+		t.Error("AuthorProfile() of an unknown user succeeded")
+	}
+}
@@ -0,0 +1,293 @@
+package myteam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-go"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultMyteamURL = "https://api.icq.net/bot/v1"
+
+type MyteamBot struct {
+	*botgolang.Bot
+	subscr  onlineconfbot.SubscriptionStorage
+	url     string
+	token   string
+	html    bool
+	client  *http.Client
+	limiter *onlineconfbot.RateLimiter
+}
+
+var _ onlineconfbot.Bot = &MyteamBot{}
+var _ onlineconfbot.ProfileProvider = &MyteamBot{}
+var _ onlineconfbot.MessageRenderer = &MyteamBot{}
+
+func NewMyteamBot(config *onlineconf.Module, subscr onlineconfbot.SubscriptionStorage) (*MyteamBot, error) {
+	var opts []botgolang.BotOption
+	apiURL := config.GetString("/myteam/url", "")
+	if apiURL != "" {
+		opts = append(opts, botgolang.BotApiURL(apiURL))
+	} else {
+		apiURL = defaultMyteamURL
+	}
+	if config.GetBool("/myteam/debug", false) {
+		opts = append(opts, botgolang.BotDebug(true))
+	}
+	token := config.GetString("/myteam/token", "")
+	bot, err := botgolang.NewBot(token, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var useHTML bool
+	switch format := config.GetString("/myteam/format", "html"); format {
+	case "html":
+		useHTML = true
+	case "text":
+	default:
+		return nil, fmt.Errorf("unknown /myteam/format %q, use html or text", format)
+	}
+
+	return &MyteamBot{
+		Bot:     bot,
+		subscr:  subscr,
+		url:     strings.TrimRight(apiURL, "/"),
+		token:   token,
+		html:    useHTML,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		limiter: onlineconfbot.RateLimiterFromConfig(config, "/myteam"),
+	}, nil
+}
+
+func (bot *MyteamBot) UpdatesProcessor(ctx context.Context) {
+	for event := range bot.GetUpdatesChannel(ctx) {
+		switch event.Type {
+		case botgolang.NEW_MESSAGE, botgolang.EDITED_MESSAGE:
+			if args := strings.Fields(event.Payload.Text); len(args) > 0 && (args[0] == "/failed" || args[0] == "/replay") {
+				if onlineconfbot.IsAdmin(bot, event.Payload.From.ID) {
+					var err error
+					if args[0] == "/failed" {
+						err = bot.sendFailed(ctx, event.Payload.From.ID, args[1:])
+					} else {
+						err = bot.replay(ctx, event.Payload.From.ID, args[1:])
+					}
+					if err != nil {
+						log.Ctx(ctx).Error().Err(err).Str("command", args[0]).Msg("failed to handle admin command")
+					}
+				}
+				break
+			}
+			switch event.Payload.Text {
+			case "/start":
+				err := bot.sendSubscribePrompt(ctx, event.Payload.From.ID)
+				if err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("failed to send subscribe prompt")
+				}
+			case "/stop":
+				err := bot.unsubscribe(ctx, event.Payload.From.ID)
+				if err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("failed to unsubscribe")
+				}
+			case "/subscribers":
+				if onlineconfbot.IsAdmin(bot, event.Payload.From.ID) {
+					err := bot.sendSubscribers(ctx, event.Payload.From.ID)
+					if err != nil {
+						log.Ctx(ctx).Error().Err(err).Msg("failed to send subscribes")
+					}
+				}
+			}
+		case botgolang.CALLBACK_QUERY:
+			var err error
+			switch event.Payload.CallbackData {
+			case "subscribe write":
+				err = bot.subscribe(ctx, event.Payload.From.ID, true)
+			case "subscribe read":
+				err = bot.subscribe(ctx, event.Payload.From.ID, false)
+			}
+			responseText := ""
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to subscribe")
+				responseText = "Internal error"
+			}
+			response := bot.NewButtonResponse(event.Payload.QueryID, "", responseText, false)
+			if err := bot.limiter.Do(ctx, response.Send); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to answer callback query")
+			}
+		}
+	}
+}
+
+func (bot *MyteamBot) sendSubscribePrompt(ctx context.Context, user string) error {
+	keyboard := [][]botgolang.Button{{
+		{Text: "I can edit", CallbackData: "subscribe write"},
+		{Text: "I can view", CallbackData: "subscribe read"},
+	}}
+	return bot.limiter.Do(ctx, func() error {
+		return bot.sendText(ctx, user, "Choose parameters you want to subscribe to", "", keyboard)
+	})
+}
+
+// reply sends a plain text message through the rate limiter.
+func (bot *MyteamBot) reply(ctx context.Context, user, text string) error {
+	return bot.limiter.Do(ctx, func() error {
+		return bot.sendText(ctx, user, text, "", nil)
+	})
+}
+
+func (bot *MyteamBot) subscribe(ctx context.Context, user string, wo bool) error {
+	err := bot.subscr.Subscribe(ctx, user, wo)
+	if err != nil {
+		return err
+	}
+	var which string
+	if wo {
+		which = "edit"
+	} else {
+		which = "view"
+	}
+	return bot.reply(ctx, user, "You subscribed to parameters you can "+which)
+}
+
+func (bot *MyteamBot) unsubscribe(ctx context.Context, user string) error {
+	err := bot.subscr.Unsubscribe(ctx, user)
+	if err != nil {
+		return err
+	}
+	return bot.reply(ctx, user, "You unsubscribed")
+}
+
+func (bot *MyteamBot) sendSubscribers(ctx context.Context, user string) error {
+	subscribers, err := bot.subscr.Subscribers(ctx)
+	if err != nil {
+		return err
+	}
+	text := strings.Builder{}
+	for _, subscr := range subscribers {
+		text.WriteString("@[")
+		text.WriteString(subscr.User)
+		text.WriteString("] ")
+		if subscr.WO {
+			text.WriteString("edit")
+		} else {
+			text.WriteString("view")
+		}
+		text.WriteString("\n")
+	}
+	return bot.reply(ctx, user, text.String())
+}
+
+func (bot *MyteamBot) sendFailed(ctx context.Context, user string, args []string) error {
+	reply, err := onlineconfbot.FailedCommand(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return bot.reply(ctx, user, reply)
+}
+
+func (bot *MyteamBot) replay(ctx context.Context, user string, args []string) error {
+	return bot.reply(ctx, user, onlineconfbot.ReplayCommand(ctx, bot, user, args...))
+}
+
+func (bot *MyteamBot) Notify(ctx context.Context, user string, msg *onlineconfbot.Message) error {
+	var keyboard [][]botgolang.Button
+	if msg.Link != "" {
+		keyboard = [][]botgolang.Button{{{Text: "Open", URL: msg.Link}}}
+	}
+
+	var parseMode string
+	if bot.html {
+		parseMode = "HTML"
+	}
+	text := bot.Render(msg).(string)
+	return bot.limiter.Do(ctx, func() error {
+		return bot.sendText(ctx, user, text, parseMode, keyboard)
+	})
+}
+
+func (bot *MyteamBot) Render(msg *onlineconfbot.Message) any {
+	if !bot.html {
+		return msg.Text
+	}
+	return msg.Format(onlineconfbot.HTML)
+}
+
+type myteamResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+// sendText calls messages/sendText directly, the bot library is unable to set parseMode
+// and does not report HTTP 429 as RateLimitedError.
+func (bot *MyteamBot) sendText(ctx context.Context, chatID, text, parseMode string, keyboard [][]botgolang.Button) error {
+	params := url.Values{
+		"token":  {bot.token},
+		"chatId": {chatID},
+		"text":   {text},
+	}
+	if parseMode != "" {
+		params.Set("parseMode", parseMode)
+	}
+	if len(keyboard) > 0 {
+		markup, err := json.Marshal(keyboard)
+		if err != nil {
+			return err
+		}
+		params.Set("inlineKeyboardMarkup", string(markup))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bot.url+"/messages/sendText", strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := bot.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &onlineconfbot.RateLimitedError{RetryAfter: onlineconfbot.ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	var result myteamResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("messages/sendText: status %d: %w", resp.StatusCode, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("messages/sendText failed: %s", result.Description)
+	}
+	return nil
+}
+
+func (bot *MyteamBot) AuthorProfile(ctx context.Context, user string) (*onlineconfbot.AuthorProfile, error) {
+	var chat *botgolang.Chat
+	err := bot.limiter.Do(ctx, func() (err error) {
+		chat, err = bot.GetChatInfo(user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &onlineconfbot.AuthorProfile{
+		DisplayName: strings.TrimSpace(chat.FirstName + " " + chat.LastName),
+	}, nil
+}
+
+func (bot *MyteamBot) MentionLink(user string) string {
+	return "@[" + user + "]"
+}
+
+func (bot *MyteamBot) ParamLink(param, link string) string {
+	return param
+}
@@ -0,0 +1,81 @@
+package myteam
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+)
+
+func TestRender(t *testing.T) {
+	value := onlineconfbot.NullString{NullString: sql.NullString{String: "a < b", Valid: true}}
+	msg := &onlineconfbot.Message{
+		Text:  "2024-01-05 12:00:00 alice MODIFIED /app/<db> = a < b",
+		Label: "replay",
+		Style: onlineconfbot.StylePlain,
+		Notifications: []*onlineconfbot.Notification{{
+			Path: "/app/<db>", ContentType: "text/plain", Value: value, MTime: "2024-01-05 12:00:00",
+			Comment: "x & y", Action: "modify", Notification: "with-value",
+		}},
+	}
+
+	tests := []struct {
+		name string
+		html bool
+		msg  *onlineconfbot.Message
+		want string
+	}{
+		{name: "text", msg: msg, want: msg.Text},
+		{
+			name: "html",
+			html: true,
+			msg:  msg,
+			want: "(replay)\n2024-01-05 12:00:00\nAuthor: \nMODIFIED /app/&lt;db&gt;\n<pre>a &lt; b</pre>\nComment: x &amp; y",
+		},
+		{name: "html without notifications", html: true, msg: &onlineconfbot.Message{Text: "<b>alert</b>"}, want: "&lt;b&gt;alert&lt;/b&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (&MyteamBot{html: tt.html}).Render(tt.msg); got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyParseMode(t *testing.T) {
+	for _, html := range []bool{false, true} {
+		var form map[string][]string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			form = r.PostForm
+			w.Write([]byte(`{"ok":true}`))
+		}))
+
+		bot := &MyteamBot{url: server.URL, token: "secret", html: html, client: server.Client(), limiter: onlineconfbot.NewRateLimiter(0, 1)}
+		err := bot.Notify(context.Background(), "alice@example.com", &onlineconfbot.Message{Text: "a < b", Link: "https://oc.example.com/#/app"})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]string{"chatId": "alice@example.com", "text": "a < b", "parseMode": ""}
+		if html {
+			want["text"], want["parseMode"] = "a &lt; b", "HTML"
+		}
+		for k, v := range want {
+			if got := form[k]; v == "" && got != nil || v != "" && (len(got) != 1 || got[0] != v) {
+				t.Errorf("html %v: %s = %q, want %q", html, k, got, v)
+			}
+		}
+		if form["inlineKeyboardMarkup"] == nil {
+			t.Errorf("html %v: no Open button", html)
+		}
+	}
+}
@@ -0,0 +1,622 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxSectionLength = 3000            // characters of a Block Kit section text
+	notFoundTTL      = 5 * time.Minute // how long emails unknown to Slack are not looked up again
+)
+
+// SlackBot addresses users by their emails, Slack user IDs and DM channels are looked up and cached.
+// Commands are received through Socket Mode, so the bot needs no public endpoint.
+type SlackBot struct {
+	apiURL   string
+	token    string // bot token, xoxb-
+	appToken string // app-level token with connections:write, xapp-
+	subscr   onlineconfbot.SubscriptionStorage
+	client   *http.Client
+	limiter  *onlineconfbot.RateLimiter
+
+	mu       sync.Mutex
+	ids      map[string]string    // user IDs by email
+	emails   map[string]string    // emails by user ID
+	channels map[string]string    // DM channel IDs by user ID
+	notFound map[string]time.Time // expiration of users_not_found by email
+}
+
+var _ onlineconfbot.Bot = &SlackBot{}
+var _ onlineconfbot.ProfileProvider = &SlackBot{}
+var _ onlineconfbot.MessageRenderer = &SlackBot{}
+var _ onlineconfbot.SharedUpdatesProcessor = &SlackBot{}
+
+func NewSlackBot(config *onlineconf.Module, subscr onlineconfbot.SubscriptionStorage) (*SlackBot, error) {
+	token := config.GetString("/slack/token", "")
+	if token == "" {
+		return nil, errors.New("please specify the Slack bot token using /slack/token")
+	}
+
+	appToken := config.GetString("/slack/app-token", "")
+	if appToken == "" {
+		return nil, errors.New("please specify the Slack app-level token for Socket Mode using /slack/app-token")
+	}
+
+	return &SlackBot{
+		apiURL:   strings.TrimRight(config.GetString("/slack/api-url", "https://slack.com/api"), "/"),
+		token:    token,
+		appToken: appToken,
+		subscr:   subscr,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		limiter:  onlineconfbot.RateLimiterFromConfig(config, "/slack"),
+		ids:      make(map[string]string),
+		emails:   make(map[string]string),
+		channels: make(map[string]string),
+		notFound: make(map[string]time.Time),
+	}, nil
+}
+
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// mrkdwn is the markup of Block Kit text objects.
+var mrkdwn = &onlineconfbot.Markup{
+	Escape: mrkdwnEscaper.Replace,
+	CodeBlock: func(code, _ string) string {
+		return "```\n" + mrkdwnEscaper.Replace(strings.TrimSuffix(code, "\n")) + "\n```"
+	},
+	Link: func(text, url string) string {
+		return "<" + mrkdwnEscaper.Replace(url) + "|" + text + ">"
+	},
+}
+
+// Slack API types
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// apiError is an error code returned by a Web API method, e.g. users_not_found.
+type apiError struct {
+	method string
+	code   string
+}
+
+func (e *apiError) Error() string {
+	return e.method + " failed: " + e.code
+}
+
+type slackUser struct {
+	ID       string `json:"id"`
+	RealName string `json:"real_name"`
+	Profile  struct {
+		Email   string `json:"email"`
+		Image48 string `json:"image_48"`
+	} `json:"profile"`
+}
+
+type slackEnvelope struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type slackEventPayload struct {
+	Event struct {
+		Type        string `json:"type"`
+		Subtype     string `json:"subtype"`
+		BotID       string `json:"bot_id"`
+		User        string `json:"user"`
+		Text        string `json:"text"`
+		Channel     string `json:"channel"`
+		ChannelType string `json:"channel_type"`
+	} `json:"event"`
+}
+
+type slackInteractivePayload struct {
+	Type string `json:"type"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+	} `json:"actions"`
+}
+
+type slackMessage struct {
+	Channel string       `json:"channel"`
+	Text    string       `json:"text"`
+	Blocks  []slackBlock `json:"blocks,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *slackText     `json:"text,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackElement struct {
+	Type     string     `json:"type"`
+	Text     *slackText `json:"text,omitempty"`
+	ActionID string     `json:"action_id,omitempty"`
+	URL      string     `json:"url,omitempty"`
+}
+
+// SharedUpdates is true since Slack hands each Socket Mode event to a single connection.
+func (bot *SlackBot) SharedUpdates() bool {
+	return true
+}
+
+func (bot *SlackBot) UpdatesProcessor(ctx context.Context) {
+	for {
+		err := bot.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue // Slack asked to reconnect
+		}
+
+		log.Ctx(ctx).Error().Err(err).Msg("socket mode connection failed")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// listen handles events of a single Socket Mode connection until Slack asks to reconnect or the connection breaks.
+func (bot *SlackBot) listen(ctx context.Context) error {
+	var connection struct {
+		URL string `json:"url"`
+	}
+	if err := bot.call(ctx, bot.appToken, "apps.connections.open", url.Values{}, &connection); err != nil {
+		return err
+	}
+
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, connection.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	for {
+		var envelope slackEnvelope
+		if err := ws.ReadJSON(&envelope); err != nil {
+			return err
+		}
+
+		if envelope.EnvelopeID != "" {
+			if err := ws.WriteJSON(map[string]string{"envelope_id": envelope.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+
+		switch envelope.Type {
+		case "disconnect":
+			return nil
+		case "events_api":
+			var payload slackEventPayload
+			if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to parse event")
+				continue
+			}
+			event := payload.Event
+			if event.Type == "message" && event.ChannelType == "im" && event.Subtype == "" && event.BotID == "" {
+				bot.handleMessage(ctx, event.Channel, event.User, event.Text)
+			}
+		case "interactive":
+			var payload slackInteractivePayload
+			if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to parse interaction")
+				continue
+			}
+			if payload.Type == "block_actions" && len(payload.Actions) > 0 {
+				bot.handleAction(ctx, payload.Channel.ID, payload.User.ID, payload.Actions[0].ActionID)
+			}
+		}
+	}
+}
+
+func (bot *SlackBot) handleMessage(ctx context.Context, channel, userID, text string) {
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(text), "/"))
+	if len(fields) == 0 {
+		return
+	}
+	cmd := strings.ToLower(fields[0])
+	args := fields[1:]
+
+	user, err := bot.userEmail(ctx, userID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("user", userID).Msg("failed to get user email")
+		return
+	}
+
+	switch cmd {
+	case "subscribe":
+		switch {
+		case len(args) == 0:
+			err = bot.sendSubscribePrompt(ctx, channel)
+		case args[0] == "edit":
+			err = bot.subscribe(ctx, channel, user, true)
+		case args[0] == "view":
+			err = bot.subscribe(ctx, channel, user, false)
+		default:
+			err = bot.sendText(ctx, channel, "Usage: subscribe [edit|view]")
+		}
+	case "unsubscribe":
+		if err = bot.subscr.Unsubscribe(ctx, user); err == nil {
+			err = bot.sendText(ctx, channel, "You unsubscribed")
+		}
+	case "subscribers", "failed", "replay":
+		if onlineconfbot.IsAdmin(bot, user) {
+			err = bot.handleAdminCommand(ctx, channel, user, cmd, args)
+		} else {
+			log.Ctx(ctx).Warn().Str("user", user).Str("command", cmd).Msg("non-admin attempted admin command")
+		}
+	case "help":
+		err = bot.sendHelp(ctx, channel, user)
+	default:
+		err = bot.sendText(ctx, channel, "Unknown command. Use help to see available commands.")
+	}
+
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("command", cmd).Str("user", user).Msg("failed to handle command")
+	}
+}
+
+func (bot *SlackBot) handleAction(ctx context.Context, channel, userID, actionID string) {
+	var wo bool
+	switch actionID {
+	case "subscribe write":
+		wo = true
+	case "subscribe read":
+	default:
+		return
+	}
+
+	user, err := bot.userEmail(ctx, userID)
+	if err == nil {
+		err = bot.subscribe(ctx, channel, user, wo)
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to subscribe")
+	}
+}
+
+func (bot *SlackBot) sendSubscribePrompt(ctx context.Context, channel string) error {
+	return bot.post(ctx, &slackMessage{
+		Channel: channel,
+		Text:    "Choose parameters you want to subscribe to",
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{Type: "plain_text", Text: "Choose parameters you want to subscribe to"}},
+			{Type: "actions", Elements: []slackElement{
+				{Type: "button", Text: &slackText{Type: "plain_text", Text: "I can edit"}, ActionID: "subscribe write"},
+				{Type: "button", Text: &slackText{Type: "plain_text", Text: "I can view"}, ActionID: "subscribe read"},
+			}},
+		},
+	})
+}
+
+func (bot *SlackBot) subscribe(ctx context.Context, channel, user string, wo bool) error {
+	if err := bot.subscr.Subscribe(ctx, user, wo); err != nil {
+		return err
+	}
+	which := "view"
+	if wo {
+		which = "edit"
+	}
+	return bot.sendText(ctx, channel, "You subscribed to parameters you can "+which)
+}
+
+func (bot *SlackBot) handleAdminCommand(ctx context.Context, channel, user, cmd string, args []string) error {
+	switch cmd {
+	case "subscribers":
+		subscribers, err := bot.subscr.Subscribers(ctx)
+		if err != nil {
+			return err
+		}
+		text := strings.Builder{}
+		text.WriteString("Subscribers:\n")
+		for _, subscr := range subscribers {
+			text.WriteString(subscr.User)
+			if subscr.WO {
+				text.WriteString(" - edit\n")
+			} else {
+				text.WriteString(" - view\n")
+			}
+		}
+		return bot.sendText(ctx, channel, text.String())
+	case "failed":
+		reply, err := onlineconfbot.FailedCommand(ctx, args...)
+		if err != nil {
+			return err
+		}
+		return bot.sendText(ctx, channel, reply)
+	case "replay":
+		return bot.sendText(ctx, channel, onlineconfbot.ReplayCommand(ctx, bot, user, args...))
+	}
+	return nil
+}
+
+func (bot *SlackBot) sendHelp(ctx context.Context, channel, user string) error {
+	text := "Available commands:\n" +
+		"subscribe [edit|view] - Subscribe to notifications\n" +
+		"unsubscribe - Unsubscribe from notifications\n" +
+		"help - Show this help"
+	if onlineconfbot.IsAdmin(bot, user) {
+		text += "\nsubscribers - Show subscribed users (admin only)"
+		text += "\nfailed list|fallback|retry <id|all>|drop <id|all> - Show, retry or drop failed deliveries, show fallback deliveries (admin only)"
+		text += "\nreplay <from-id> [<to-id>] [<user>] - Send notifications again (admin only)"
+	}
+	return bot.sendText(ctx, channel, text)
+}
+
+func (bot *SlackBot) Notify(ctx context.Context, user string, msg *onlineconfbot.Message) error {
+	id, err := bot.userID(ctx, user)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return fmt.Errorf("no Slack user with email %s", user)
+	}
+
+	channel, err := bot.directChannel(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	message := bot.Render(msg).(*slackMessage)
+	message.Channel = channel
+	return bot.post(ctx, message)
+}
+
+// Render lays out each notification as a mrkdwn section, compact digests and service messages as a single one.
+func (bot *SlackBot) Render(msg *onlineconfbot.Message) any {
+	message := &slackMessage{Text: truncate(msg.Format(mrkdwn))}
+
+	if onlineconfbot.IsCompact(msg.Style) || len(msg.Notifications) == 0 {
+		message.Blocks = []slackBlock{section(message.Text)}
+	} else {
+		if msg.Label != "" {
+			message.Blocks = append(message.Blocks, slackBlock{
+				Type:     "context",
+				Elements: []slackElement{{Type: "mrkdwn", Text: &slackText{Type: "mrkdwn", Text: mrkdwn.Escape("(" + msg.Label + ")")}}},
+			})
+		}
+		for _, notification := range msg.Notifications {
+			message.Blocks = append(message.Blocks, section(truncate(notification.Format(msg.Style, mrkdwn))))
+		}
+	}
+
+	if msg.Link != "" {
+		message.Blocks = append(message.Blocks, slackBlock{
+			Type:     "actions",
+			Elements: []slackElement{{Type: "button", Text: &slackText{Type: "plain_text", Text: "Open"}, URL: msg.Link, ActionID: "open"}},
+		})
+	}
+	return message
+}
+
+func section(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+}
+
+func truncate(text string) string {
+	if r := []rune(text); len(r) > maxSectionLength {
+		return string(r[:maxSectionLength-1]) + "…"
+	}
+	return text
+}
+
+// AuthorProfile looks the user up by email, emails unknown to Slack are not looked up again for notFoundTTL.
+func (bot *SlackBot) AuthorProfile(ctx context.Context, user string) (*onlineconfbot.AuthorProfile, error) {
+	bot.mu.Lock()
+	expires, ok := bot.notFound[user]
+	bot.mu.Unlock()
+	if ok && time.Now().Before(expires) {
+		return nil, nil
+	}
+
+	var result struct {
+		User slackUser `json:"user"`
+	}
+	err := bot.limiter.Do(ctx, func() error {
+		return bot.call(ctx, bot.token, "users.lookupByEmail", url.Values{"email": {user}}, &result)
+	})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.code == "users_not_found" {
+		bot.mu.Lock()
+		bot.notFound[user] = time.Now().Add(notFoundTTL)
+		bot.mu.Unlock()
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	bot.remember(result.User.ID, user)
+	return &onlineconfbot.AuthorProfile{DisplayName: result.User.RealName, IconURL: result.User.Profile.Image48}, nil
+}
+
+// MentionLink mentions the user if their Slack ID is already known, the email is shown otherwise.
+func (bot *SlackBot) MentionLink(user string) string {
+	bot.mu.Lock()
+	id, ok := bot.ids[user]
+	bot.mu.Unlock()
+	if ok {
+		return "<@" + id + ">"
+	}
+	return mrkdwn.Escape(user)
+}
+
+func (bot *SlackBot) ParamLink(param, link string) string {
+	if link == "" {
+		return mrkdwn.Escape(param)
+	}
+	return mrkdwn.Link(mrkdwn.Escape(param), link)
+}
+
+// users and channels
+
+func (bot *SlackBot) remember(id, email string) {
+	bot.mu.Lock()
+	bot.ids[email] = id
+	bot.emails[id] = email
+	delete(bot.notFound, email)
+	bot.mu.Unlock()
+}
+
+func (bot *SlackBot) userID(ctx context.Context, email string) (string, error) {
+	bot.mu.Lock()
+	id, ok := bot.ids[email]
+	bot.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	if _, err := bot.AuthorProfile(ctx, email); err != nil {
+		return "", err
+	}
+
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	return bot.ids[email], nil
+}
+
+func (bot *SlackBot) userEmail(ctx context.Context, id string) (string, error) {
+	bot.mu.Lock()
+	email, ok := bot.emails[id]
+	bot.mu.Unlock()
+	if ok {
+		return email, nil
+	}
+
+	var result struct {
+		User slackUser `json:"user"`
+	}
+	err := bot.limiter.Do(ctx, func() error {
+		return bot.call(ctx, bot.token, "users.info", url.Values{"user": {id}}, &result)
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.User.Profile.Email == "" {
+		return "", fmt.Errorf("email of Slack user %s is not available, users:read.email scope is required", id)
+	}
+	bot.remember(id, result.User.Profile.Email)
+	return result.User.Profile.Email, nil
+}
+
+func (bot *SlackBot) directChannel(ctx context.Context, id string) (string, error) {
+	bot.mu.Lock()
+	channel, ok := bot.channels[id]
+	bot.mu.Unlock()
+	if ok {
+		return channel, nil
+	}
+
+	var result struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	err := bot.limiter.Do(ctx, func() error {
+		return bot.call(ctx, bot.token, "conversations.open", url.Values{"users": {id}}, &result)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	bot.mu.Lock()
+	bot.channels[id] = result.Channel.ID
+	bot.mu.Unlock()
+	return result.Channel.ID, nil
+}
+
+// HTTP helpers
+
+func (bot *SlackBot) sendText(ctx context.Context, channel, text string) error {
+	return bot.post(ctx, &slackMessage{Channel: channel, Text: mrkdwn.Escape(text)})
+}
+
+func (bot *SlackBot) post(ctx context.Context, message *slackMessage) error {
+	params := url.Values{
+		"channel":      {message.Channel},
+		"text":         {message.Text},
+		"unfurl_links": {"false"},
+	}
+	if len(message.Blocks) > 0 {
+		blocks, err := json.Marshal(message.Blocks)
+		if err != nil {
+			return err
+		}
+		params.Set("blocks", string(blocks))
+	}
+
+	return bot.limiter.Do(ctx, func() error {
+		return bot.call(ctx, bot.token, "chat.postMessage", params, nil)
+	})
+}
+
+// call calls the Web API method and decodes the response into result unless it is nil.
+func (bot *SlackBot) call(ctx context.Context, token, method string, params url.Values, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bot.apiURL+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", method, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := bot.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &onlineconfbot.RateLimitedError{RetryAfter: onlineconfbot.ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("%s: status %d: %w", method, resp.StatusCode, err)
+	}
+
+	var response slackResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("unmarshal %s response: %w", method, err)
+	}
+	if !response.OK {
+		return &apiError{method: method, code: response.Error}
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("unmarshal %s response: %w", method, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,512 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-go"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultWait = 50 // seconds of getUpdates long polling
+
+// TelegramBot addresses users by their OnlineConf usernames. Telegram has no corporate logins,
+// so chat IDs are linked to usernames by admins or by /telegram/accounts when the user sends /start.
+type TelegramBot struct {
+	apiURL   string
+	config   *onlineconf.Module
+	subscr   onlineconfbot.SubscriptionStorage
+	accounts onlineconfbot.AccountStorage
+	client   *http.Client
+	limiter  *onlineconfbot.RateLimiter
+	wait     int
+}
+
+var _ onlineconfbot.Bot = &TelegramBot{}
+var _ onlineconfbot.MessageRenderer = &TelegramBot{}
+
+func NewTelegramBot(config *onlineconf.Module, subscr onlineconfbot.SubscriptionStorage) (*TelegramBot, error) {
+	token := config.GetString("/telegram/token", "")
+	if token == "" {
+		return nil, errors.New("please specify the Telegram bot token using /telegram/token")
+	}
+
+	accounts, ok := subscr.(onlineconfbot.AccountStorage)
+	if !ok {
+		return nil, errors.New("subscription storage is unable to link Telegram chats")
+	}
+
+	apiURL := config.GetString("/telegram/api-url", "https://api.telegram.org")
+	wait := config.GetInt("/telegram/wait", defaultWait)
+
+	return &TelegramBot{
+		apiURL:   strings.TrimRight(apiURL, "/") + "/bot" + token,
+		config:   config,
+		subscr:   subscr,
+		accounts: accounts,
+		client:   &http.Client{Timeout: time.Duration(wait+10) * time.Second},
+		limiter:  onlineconfbot.RateLimiterFromConfig(config, "/telegram"),
+		wait:     wait,
+	}, nil
+}
+
+// markdownV2 is the markup of messages sent with parse_mode MarkdownV2, which reserves
+// a lot of punctuation outside of entities.
+var markdownV2 = &onlineconfbot.Markup{
+	Escape: strings.NewReplacer(
+		`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+		">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+	).Replace,
+	CodeBlock: func(code, language string) string {
+		return "```" + language + "\n" + codeEscaper.Replace(strings.TrimSuffix(code, "\n")) + "\n```"
+	},
+	Link: func(text, url string) string {
+		return "[" + text + "](" + linkEscaper.Replace(url) + ")"
+	},
+}
+
+var codeEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+var linkEscaper = strings.NewReplacer(`\`, `\\`, ")", `\)`)
+
+// Telegram Bot API types
+
+type tgResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+type tgUpdate struct {
+	UpdateID      int              `json:"update_id"`
+	Message       *tgMessage       `json:"message"`
+	CallbackQuery *tgCallbackQuery `json:"callback_query"`
+}
+
+type tgMessage struct {
+	From *tgUser `json:"from"`
+	Chat tgChat  `json:"chat"`
+	Text string  `json:"text"`
+}
+
+type tgUser struct {
+	ID       int64  `json:"id"`
+	IsBot    bool   `json:"is_bot"`
+	Username string `json:"username"`
+}
+
+type tgChat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type tgCallbackQuery struct {
+	ID   string `json:"id"`
+	From tgUser `json:"from"`
+	Data string `json:"data"`
+}
+
+type tgGetUpdatesRequest struct {
+	Offset         int      `json:"offset"`
+	Timeout        int      `json:"timeout"`
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+type tgSendMessageRequest struct {
+	ChatID                string            `json:"chat_id"`
+	Text                  string            `json:"text"`
+	ParseMode             string            `json:"parse_mode,omitempty"`
+	DisableWebPagePreview bool              `json:"disable_web_page_preview,omitempty"`
+	ReplyMarkup           *tgInlineKeyboard `json:"reply_markup,omitempty"`
+}
+
+type tgInlineKeyboard struct {
+	InlineKeyboard [][]tgButton `json:"inline_keyboard"`
+}
+
+type tgButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+type tgAnswerCallbackQueryRequest struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+}
+
+func (bot *TelegramBot) UpdatesProcessor(ctx context.Context) {
+	offset := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		var updates []tgUpdate
+		err := bot.call(ctx, "getUpdates", tgGetUpdatesRequest{
+			Offset:         offset,
+			Timeout:        bot.wait,
+			AllowedUpdates: []string{"message", "callback_query"},
+		}, &updates)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to get updates")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			if update.UpdateID >= offset {
+				offset = update.UpdateID + 1
+			}
+
+			switch {
+			case update.Message != nil:
+				bot.handleMessage(ctx, update.Message)
+			case update.CallbackQuery != nil:
+				bot.handleCallbackQuery(ctx, update.CallbackQuery)
+			}
+		}
+	}
+}
+
+func (bot *TelegramBot) handleMessage(ctx context.Context, msg *tgMessage) {
+	if msg.Chat.Type != "private" || msg.From == nil || msg.From.IsBot {
+		return
+	}
+
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 {
+		return
+	}
+
+	cmd, _, _ := strings.Cut(fields[0], "@") // commands may be addressed as /cmd@botname
+	args := fields[1:]
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+
+	user, err := bot.accounts.AddressAccount(ctx, chatID)
+	if err == nil && user == "" && cmd == "/start" {
+		user, err = bot.linkByUsername(ctx, msg.From, chatID)
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("chat", chatID).Msg("failed to find linked account")
+		return
+	}
+
+	if user == "" {
+		err = bot.sendText(ctx, chatID, "Your Telegram account is not linked to OnlineConf. "+
+			"Ask an admin to run: /link <onlineconf-username> "+chatID)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("chat", chatID).Msg("failed to send link instructions")
+		}
+		return
+	}
+
+	switch cmd {
+	case "/start":
+		err = bot.sendSubscribePrompt(ctx, chatID)
+	case "/subscribe":
+		err = bot.handleSubscribe(ctx, chatID, user, args)
+	case "/stop":
+		err = bot.unsubscribe(ctx, chatID, user)
+	case "/subscribers", "/failed", "/replay", "/link", "/unlink":
+		if onlineconfbot.IsAdmin(bot, user) {
+			err = bot.handleAdminCommand(ctx, chatID, user, cmd, args)
+		} else {
+			log.Ctx(ctx).Warn().Str("user", user).Str("command", cmd).Msg("non-admin attempted admin command")
+		}
+	case "/help":
+		err = bot.sendHelp(ctx, chatID, user)
+	default:
+		err = bot.sendText(ctx, chatID, "Unknown command. Use /help to see available commands.")
+	}
+
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("command", cmd).Str("user", user).Msg("failed to handle command")
+	}
+}
+
+// linkByUsername links the chat to the OnlineConf user whose Telegram username is listed in /telegram/accounts.
+// A chat already linked to the user is not replaced, usernames can be taken over after they are released.
+func (bot *TelegramBot) linkByUsername(ctx context.Context, from *tgUser, chatID string) (string, error) {
+	if from.Username == "" {
+		return "", nil
+	}
+
+	var accounts map[string]string
+	bot.config.GetStruct("/telegram/accounts", &accounts)
+	for user, username := range accounts {
+		if strings.EqualFold(strings.TrimPrefix(username, "@"), from.Username) {
+			linked, err := bot.accounts.AccountAddress(ctx, user)
+			if err != nil {
+				return "", err
+			}
+			if linked != "" && linked != chatID {
+				log.Ctx(ctx).Warn().Str("user", user).Str("chat", chatID).Msg("user is linked to another telegram chat, ask an admin to relink")
+				return "", nil
+			}
+			if err := bot.accounts.LinkAccount(ctx, user, chatID); err != nil {
+				return "", err
+			}
+			log.Ctx(ctx).Info().Str("user", user).Str("chat", chatID).Msg("telegram chat linked")
+			return user, nil
+		}
+	}
+	return "", nil
+}
+
+func (bot *TelegramBot) handleCallbackQuery(ctx context.Context, query *tgCallbackQuery) {
+	chatID := strconv.FormatInt(query.From.ID, 10)
+	user, err := bot.accounts.AddressAccount(ctx, chatID)
+	if err == nil && user != "" {
+		switch query.Data {
+		case "subscribe write":
+			err = bot.subscribe(ctx, chatID, user, true)
+		case "subscribe read":
+			err = bot.subscribe(ctx, chatID, user, false)
+		}
+	}
+
+	responseText := ""
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to subscribe")
+		responseText = "Internal error"
+	} else if user == "" {
+		responseText = "Your Telegram account is not linked to OnlineConf"
+	}
+
+	err = bot.call(ctx, "answerCallbackQuery", tgAnswerCallbackQueryRequest{CallbackQueryID: query.ID, Text: responseText}, nil)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to answer callback query")
+	}
+}
+
+func (bot *TelegramBot) sendSubscribePrompt(ctx context.Context, chatID string) error {
+	return bot.send(ctx, tgSendMessageRequest{
+		ChatID: chatID,
+		Text:   "Choose parameters you want to subscribe to",
+		ReplyMarkup: &tgInlineKeyboard{InlineKeyboard: [][]tgButton{{
+			{Text: "I can edit", CallbackData: "subscribe write"},
+			{Text: "I can view", CallbackData: "subscribe read"},
+		}}},
+	})
+}
+
+func (bot *TelegramBot) handleSubscribe(ctx context.Context, chatID, user string, args []string) error {
+	if len(args) == 0 {
+		return bot.sendSubscribePrompt(ctx, chatID)
+	}
+
+	switch args[0] {
+	case "edit":
+		return bot.subscribe(ctx, chatID, user, true)
+	case "view":
+		return bot.subscribe(ctx, chatID, user, false)
+	default:
+		return bot.sendText(ctx, chatID, "Usage: /subscribe [edit|view]")
+	}
+}
+
+func (bot *TelegramBot) subscribe(ctx context.Context, chatID, user string, wo bool) error {
+	if err := bot.subscr.Subscribe(ctx, user, wo); err != nil {
+		return err
+	}
+	which := "view"
+	if wo {
+		which = "edit"
+	}
+	return bot.sendText(ctx, chatID, "You subscribed to parameters you can "+which)
+}
+
+func (bot *TelegramBot) unsubscribe(ctx context.Context, chatID, user string) error {
+	if err := bot.subscr.Unsubscribe(ctx, user); err != nil {
+		return err
+	}
+	return bot.sendText(ctx, chatID, "You unsubscribed")
+}
+
+func (bot *TelegramBot) handleAdminCommand(ctx context.Context, chatID, user, cmd string, args []string) error {
+	switch cmd {
+	case "/subscribers":
+		return bot.sendSubscribers(ctx, chatID)
+	case "/failed":
+		reply, err := onlineconfbot.FailedCommand(ctx, args...)
+		if err != nil {
+			return err
+		}
+		return bot.sendText(ctx, chatID, reply)
+	case "/replay":
+		return bot.sendText(ctx, chatID, onlineconfbot.ReplayCommand(ctx, bot, user, args...))
+	case "/link":
+		if len(args) != 2 {
+			return bot.sendText(ctx, chatID, "Usage: /link <onlineconf-username> <chat-id>")
+		}
+		if _, err := strconv.ParseInt(args[1], 10, 64); err != nil {
+			return bot.sendText(ctx, chatID, "Invalid chat id: "+args[1])
+		}
+		if err := bot.accounts.LinkAccount(ctx, args[0], args[1]); err != nil {
+			return err
+		}
+		return bot.sendText(ctx, chatID, "Chat "+args[1]+" linked to "+args[0])
+	case "/unlink":
+		if len(args) != 1 {
+			return bot.sendText(ctx, chatID, "Usage: /unlink <onlineconf-username>")
+		}
+		if err := bot.subscr.Unsubscribe(ctx, args[0]); err != nil {
+			return err
+		}
+		if err := bot.accounts.UnlinkAccount(ctx, args[0]); err != nil {
+			return err
+		}
+		return bot.sendText(ctx, chatID, args[0]+" unlinked and unsubscribed")
+	}
+	return nil
+}
+
+func (bot *TelegramBot) sendSubscribers(ctx context.Context, chatID string) error {
+	subscribers, err := bot.subscr.Subscribers(ctx)
+	if err != nil {
+		return err
+	}
+
+	text := strings.Builder{}
+	text.WriteString("Subscribers:\n")
+	for _, subscr := range subscribers {
+		text.WriteString(subscr.User)
+		text.WriteString(" - ")
+		if subscr.WO {
+			text.WriteString("edit")
+		} else {
+			text.WriteString("view")
+		}
+		text.WriteString("\n")
+	}
+	return bot.sendText(ctx, chatID, text.String())
+}
+
+func (bot *TelegramBot) sendHelp(ctx context.Context, chatID, user string) error {
+	text := "Available commands:\n" +
+		"/start - Show subscribe prompt\n" +
+		"/subscribe [edit|view] - Subscribe to notifications\n" +
+		"/stop - Unsubscribe from notifications\n" +
+		"/help - Show this help"
+	if onlineconfbot.IsAdmin(bot, user) {
+		text += "\n/subscribers - Show subscribed users (admin only)"
+		text += "\n/failed list|fallback|retry <id|all>|drop <id|all> - Show, retry or drop failed deliveries, show fallback deliveries (admin only)"
+		text += "\n/replay <from-id> [<to-id>] [<user>] - Send notifications again (admin only)"
+		text += "\n/link <onlineconf-username> <chat-id> - Link a Telegram chat to an OnlineConf user (admin only)"
+		text += "\n/unlink <onlineconf-username> - Unlink and unsubscribe an OnlineConf user (admin only)"
+	}
+	return bot.sendText(ctx, chatID, text)
+}
+
+func (bot *TelegramBot) Notify(ctx context.Context, user string, msg *onlineconfbot.Message) error {
+	chatID, err := bot.accounts.AccountAddress(ctx, user)
+	if err != nil {
+		return err
+	}
+	if chatID == "" {
+		// retries would not help until an admin links the chat
+		log.Ctx(ctx).Warn().Str("user", user).Msg("no Telegram chat is linked to the user, notification skipped")
+		return nil
+	}
+
+	req := tgSendMessageRequest{
+		ChatID:                chatID,
+		Text:                  bot.Render(msg).(string),
+		ParseMode:             "MarkdownV2",
+		DisableWebPagePreview: true,
+	}
+	if msg.Link != "" {
+		req.ReplyMarkup = &tgInlineKeyboard{InlineKeyboard: [][]tgButton{{{Text: "Open", URL: msg.Link}}}}
+	}
+	return bot.send(ctx, req)
+}
+
+func (bot *TelegramBot) Render(msg *onlineconfbot.Message) any {
+	return msg.Format(markdownV2)
+}
+
+// MentionLink returns the OnlineConf username, it is inserted into MarkdownV2 as is.
+func (bot *TelegramBot) MentionLink(user string) string {
+	return markdownV2.Escape(user)
+}
+
+func (bot *TelegramBot) ParamLink(param, link string) string {
+	if link == "" {
+		return markdownV2.Escape(param)
+	}
+	return markdownV2.Link(markdownV2.Escape(param), link)
+}
+
+// HTTP helpers
+
+func (bot *TelegramBot) sendText(ctx context.Context, chatID, text string) error {
+	return bot.send(ctx, tgSendMessageRequest{ChatID: chatID, Text: text})
+}
+
+func (bot *TelegramBot) send(ctx context.Context, req tgSendMessageRequest) error {
+	return bot.limiter.Do(ctx, func() error {
+		return bot.call(ctx, "sendMessage", req, nil)
+	})
+}
+
+// call calls the Bot API method and decodes its result into result unless it is nil.
+func (bot *TelegramBot) call(ctx context.Context, method string, req, result any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal %s request: %w", method, err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, bot.apiURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", method, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := bot.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%s request: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var response tgResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("%s: status %d: %w", method, resp.StatusCode, err)
+	}
+
+	if !response.OK {
+		if response.ErrorCode == http.StatusTooManyRequests {
+			return &onlineconfbot.RateLimitedError{
+				RetryAfter: time.Duration(response.Parameters.RetryAfter) * time.Second,
+				Err:        errors.New(response.Description),
+			}
+		}
+		return fmt.Errorf("%s failed: %s", method, response.Description)
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("unmarshal %s result: %w", method, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"testing"
+)
+
+func TestMarkdownV2(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "plain", want: "plain"},
+		{text: "/app/db-host.name", want: `/app/db\-host\.name`},
+		{text: `a_b*c[d](e)~f` + "`g`", want: `a\_b\*c\[d\]\(e\)\~f` + "\\`g\\`"},
+		{text: `>#+-=|{}.!\`, want: `\>\#\+\-\=\|\{\}\.\!\\`},
+	}
+	for _, tt := range tests {
+		if got := markdownV2.Escape(tt.text); got != tt.want {
+			t.Errorf("Escape(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	if got, want := markdownV2.CodeBlock("a`b\\c\n", "json"), "```json\na\\`b\\\\c\n```"; got != want {
+		t.Errorf("CodeBlock() = %q, want %q", got, want)
+	}
+}
+
+func TestParamLink(t *testing.T) {
+	tests := []struct {
+		param string
+		link  string
+		want  string
+	}{
+		{param: "/app/db.host", want: `/app/db\.host`},
+		{param: "/app/db.host", link: "https://oc.example.com/#/app/db.host", want: `[/app/db\.host](https://oc.example.com/#/app/db.host)`},
+		{param: "/app/(x)", link: "https://oc.example.com/#/app/(x)", want: `[/app/\(x\)](https://oc.example.com/#/app/(x\))`},
+	}
+	for _, tt := range tests {
+		if got := (&TelegramBot{}).ParamLink(tt.param, tt.link); got != tt.want {
+			t.Errorf("ParamLink(%q, %q) = %q, want %q", tt.param, tt.link, got, tt.want)
+		}
+	}
+}
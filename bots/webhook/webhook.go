@@ -0,0 +1,297 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	FormatMessage      = "message"      // one request per delivery
+	FormatNotification = "notification" // one request per raw notification
+
+	SignatureHeader = "X-OnlineConf-Signature"
+
+	defaultTimeout = 10 // seconds
+)
+
+// WebhookBot posts deliveries as JSON to the routes configured in /webhook/routes.
+// Routes are the recipients of every notification, failed requests are retried through the outbox.
+type WebhookBot struct {
+	config  *onlineconf.Module
+	client  *http.Client
+	limiter *onlineconfbot.RateLimiter
+}
+
+var _ onlineconfbot.Bot = &WebhookBot{}
+var _ onlineconfbot.Broadcaster = &WebhookBot{}
+var _ onlineconfbot.MessageRenderer = &WebhookBot{}
+
+type route struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Format string   `json:"format"` // FormatMessage if empty
+	Paths  []string `json:"paths"`  // all notifications if empty
+	Alerts bool     `json:"alerts"` // admin alerts are posted too
+}
+
+// messagePayload is the body of a FormatMessage request and of an alert.
+type messagePayload struct {
+	Route         string                `json:"route"`
+	Label         string                `json:"label,omitempty"`
+	Link          string                `json:"link,omitempty"`
+	Style         string                `json:"style"`
+	Text          string                `json:"text"`
+	Notifications []notificationPayload `json:"notifications"`
+}
+
+// notificationPayload is a notification as posted to routes: the value is hidden like in messages
+// unless the parameter notifies with value, and the access list is left out.
+type notificationPayload struct {
+	ID           int                      `json:"id"`
+	Path         string                   `json:"path"`
+	Version      int                      `json:"version"`
+	ContentType  string                   `json:"type"`
+	Value        onlineconfbot.NullString `json:"value"`
+	MTime        string                   `json:"mtime"`
+	Author       string                   `json:"author"`
+	Comment      string                   `json:"comment"`
+	Action       string                   `json:"action"`
+	Notification string                   `json:"notification"`
+}
+
+func newNotificationPayload(notification *onlineconfbot.Notification) notificationPayload {
+	redacted := notification.Redacted()
+	return notificationPayload{
+		ID:           redacted.ID,
+		Path:         redacted.Path,
+		Version:      redacted.Version,
+		ContentType:  redacted.ContentType,
+		Value:        redacted.Value,
+		MTime:        redacted.MTime,
+		Author:       redacted.Author,
+		Comment:      redacted.Comment,
+		Action:       redacted.Action,
+		Notification: redacted.Notification,
+	}
+}
+
+// request is a prepared webhook request, see Render.
+type request struct {
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body"`
+}
+
+func NewWebhookBot(config *onlineconf.Module, subscr onlineconfbot.SubscriptionStorage) (*WebhookBot, error) {
+	bot := &WebhookBot{
+		config:  config,
+		client:  &http.Client{Timeout: time.Duration(config.GetInt("/webhook/timeout", defaultTimeout)) * time.Second},
+		limiter: onlineconfbot.RateLimiterFromConfig(config, "/webhook"),
+	}
+	if len(bot.routes()) == 0 {
+		return nil, errors.New("please specify routes using /webhook/routes")
+	}
+	return bot, nil
+}
+
+func (bot *WebhookBot) routes() map[string]route {
+	var routes map[string]route
+	bot.config.GetStruct("/webhook/routes", &routes)
+	return routes
+}
+
+// UpdatesProcessor does nothing, webhooks receive no commands.
+func (bot *WebhookBot) UpdatesProcessor(ctx context.Context) {
+	<-ctx.Done()
+}
+
+// Recipients returns names of the routes.
+func (bot *WebhookBot) Recipients() []string {
+	routes := bot.routes()
+	names := make([]string, 0, len(routes))
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (bot *WebhookBot) Notify(ctx context.Context, user string, msg *onlineconfbot.Message) error {
+	r, ok := bot.routes()[user]
+	if !ok {
+		log.Ctx(ctx).Warn().Str("route", user).Msg("webhook route is not configured, delivery dropped")
+		return nil
+	}
+
+	requests, err := bot.requests(user, r, msg)
+	if err != nil {
+		return err
+	}
+	// the outbox retries the whole delivery, so requests accepted before a failure are posted again
+	// and receivers deduplicate them by notification id
+	for _, req := range requests {
+		err := bot.limiter.Do(ctx, func() error {
+			return bot.post(ctx, r.Secret, req)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render returns requests which would be sent if the message was delivered to each route.
+func (bot *WebhookBot) Render(msg *onlineconfbot.Message) any {
+	rendered := make(map[string][]request)
+	for name, r := range bot.routes() {
+		requests, err := bot.requests(name, r, msg)
+		if err != nil {
+			log.Error().Err(err).Str("route", name).Msg("failed to render webhook request")
+			continue
+		}
+		if len(requests) > 0 {
+			rendered[name] = requests
+		}
+	}
+	return rendered
+}
+
+// requests builds the requests of the route, the notifications of the message are filtered by route paths.
+// Alerts have no notifications, they are posted as a single message whatever the format of the route is.
+func (bot *WebhookBot) requests(name string, r route, msg *onlineconfbot.Message) ([]request, error) {
+	if len(msg.Notifications) == 0 {
+		if !r.Alerts {
+			return nil, nil
+		}
+		body, err := json.Marshal(messagePayload{
+			Route:         name,
+			Label:         msg.Label,
+			Style:         msg.Style,
+			Text:          msg.Text,
+			Notifications: []notificationPayload{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []request{{URL: r.URL, Body: body}}, nil
+	}
+
+	notifications := make([]*onlineconfbot.Notification, 0, len(msg.Notifications))
+	for _, notification := range msg.Notifications {
+		if matchPaths(r.Paths, notification.Path) {
+			notifications = append(notifications, notification)
+		}
+	}
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	switch r.Format {
+	case FormatMessage, "":
+		filtered := msg
+		if len(notifications) != len(msg.Notifications) {
+			filtered = &onlineconfbot.Message{Style: msg.Style, Label: msg.Label, Notifications: notifications}
+			if len(notifications) == 1 {
+				filtered.Link = notifications[0].Link()
+			}
+			filtered.Text = filtered.Format(onlineconfbot.Markdown)
+		}
+		payload := messagePayload{
+			Route:         name,
+			Label:         filtered.Label,
+			Link:          filtered.Link,
+			Style:         filtered.Style,
+			Text:          filtered.Text,
+			Notifications: make([]notificationPayload, len(filtered.Notifications)),
+		}
+		for i, notification := range filtered.Notifications {
+			payload.Notifications[i] = newNotificationPayload(notification)
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		return []request{{URL: r.URL, Body: body}}, nil
+
+	case FormatNotification:
+		requests := make([]request, 0, len(notifications))
+		for _, notification := range notifications {
+			body, err := json.Marshal(newNotificationPayload(notification))
+			if err != nil {
+				return nil, err
+			}
+			requests = append(requests, request{URL: r.URL, Body: body})
+		}
+		return requests, nil
+
+	default:
+		return nil, fmt.Errorf("unknown format %q of webhook route %s, use %s or %s", r.Format, name, FormatMessage, FormatNotification)
+	}
+}
+
+func (bot *WebhookBot) post(ctx context.Context, secret string, r request) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(r.Body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := bot.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &onlineconfbot.RateLimitedError{RetryAfter: onlineconfbot.ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with status %d", r.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// matchPaths reports whether the path is one of paths or their descendant, an empty list matches everything.
+func matchPaths(paths []string, path string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, prefix := range paths {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (bot *WebhookBot) MentionLink(user string) string {
+	return user
+}
+
+func (bot *WebhookBot) ParamLink(param, link string) string {
+	if link == "" {
+		return param
+	}
+	return "[" + param + "](" + link + ")"
+}
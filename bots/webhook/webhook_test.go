@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"database/sql"
+	"testing"
+
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+)
+
+func TestMatchPaths(t *testing.T) {
+	tests := []struct {
+		paths []string
+		path  string
+		want  bool
+	}{
+		{paths: nil, path: "/app/db", want: true},
+		{paths: []string{"/app"}, path: "/app", want: true},
+		{paths: []string{"/app"}, path: "/app/db/host", want: true},
+		{paths: []string{"/app/"}, path: "/app/db", want: true},
+		{paths: []string{"/app"}, path: "/application", want: false},
+		{paths: []string{"/app"}, path: "/", want: false},
+		{paths: []string{"/other", "/app/db"}, path: "/app/db/port", want: true},
+		{paths: []string{"/other", "/app/db"}, path: "/app/cache", want: false},
+		{paths: []string{"/"}, path: "/app", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := matchPaths(tt.paths, tt.path); got != tt.want {
+			t.Errorf("matchPaths(%q, %q) = %v, want %v", tt.paths, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRequestsNotificationFormat(t *testing.T) {
+	secret := onlineconfbot.NullString{NullString: sql.NullString{String: "secret", Valid: true}}
+	shown := onlineconfbot.NullString{NullString: sql.NullString{String: "shown", Valid: true}}
+	msg := &onlineconfbot.Message{Notifications: []*onlineconfbot.Notification{
+		{ID: 1, Path: "/app/password", Value: secret, Notification: "no-value", Users: map[string]string{"alice": "rw"}},
+		{ID: 2, Path: "/app/host", Value: shown, Notification: "with-value", Users: map[string]string{"alice": "rw"}},
+		{ID: 3, Path: "/other", Value: shown, Notification: "with-value"},
+	}}
+	r := route{URL: "http://example.com/hook", Format: FormatNotification, Paths: []string{"/app"}}
+
+	requests, err := (&WebhookBot{}).requests("dashboard", r, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"id":1,"path":"/app/password","version":0,"type":"","value":null,"mtime":"","author":"","comment":"","action":"","notification":"no-value"}`,
+		`{"id":2,"path":"/app/host","version":0,"type":"","value":"shown","mtime":"","author":"","comment":"","action":"","notification":"with-value"}`,
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("requests() returned %d requests, want %d", len(requests), len(want))
+	}
+	for i, req := range requests {
+		if string(req.Body) != want[i] {
+			t.Errorf("request %d body = %s, want %s", i, req.Body, want[i])
+		}
+	}
+}
+
+func TestRequestsAlert(t *testing.T) {
+	alert := &onlineconfbot.Message{Text: "BotAPI is unavailable", Style: onlineconfbot.StylePlain}
+	tests := []struct {
+		route route
+		want  string
+	}{
+		{
+			route: route{URL: "http://example.com/hook", Format: FormatNotification},
+		},
+		{
+			route: route{URL: "http://example.com/hook", Format: FormatNotification, Alerts: true},
+			want:  `{"route":"dashboard","style":"plain","text":"BotAPI is unavailable","notifications":[]}`,
+		},
+		{
+			route: route{URL: "http://example.com/hook", Alerts: true},
+			want:  `{"route":"dashboard","style":"plain","text":"BotAPI is unavailable","notifications":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		requests, err := (&WebhookBot{}).requests("dashboard", tt.route, alert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.want == "" {
+			if len(requests) != 0 {
+				t.Errorf("requests(%+v) returned %d requests, want none", tt.route, len(requests))
+			}
+			continue
+		}
+		if len(requests) != 1 || string(requests[0].Body) != tt.want {
+			t.Errorf("requests(%+v) = %+v, want one request with body %s", tt.route, requests, tt.want)
+		}
+	}
+}
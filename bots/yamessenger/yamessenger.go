@@ -1,4 +1,4 @@
-package main
+package yamessenger
 
 import (
 	"bytes"
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	onlineconfbot "github.com/onlineconf/onlineconf-bot"
@@ -17,13 +18,19 @@ import (
 )
 
 type YaMessengerBot struct {
-	apiURL string
-	token  string
-	subscr onlineconfbot.SubscriptionStorage
-	client *http.Client
+	apiURL  string
+	token   string
+	subscr  onlineconfbot.SubscriptionStorage
+	client  *http.Client
+	limiter *onlineconfbot.RateLimiter
+
+	// Bot API has no user info method, so display names are remembered from incoming messages.
+	namesMu sync.Mutex
+	names   map[string]string
 }
 
 var _ onlineconfbot.Bot = &YaMessengerBot{}
+var _ onlineconfbot.ProfileProvider = &YaMessengerBot{}
 
 func NewYaMessengerBot(config *onlineconf.Module, subscr onlineconfbot.SubscriptionStorage) (*YaMessengerBot, error) {
 	apiURL := config.GetString("/yamessenger/api-url", "https://botapi.messenger.yandex.net")
@@ -33,10 +40,12 @@ func NewYaMessengerBot(config *onlineconf.Module, subscr onlineconfbot.Subscript
 	}
 
 	return &YaMessengerBot{
-		apiURL: strings.TrimRight(apiURL, "/"),
-		token:  token,
-		subscr: subscr,
-		client: &http.Client{Timeout: 30 * time.Second},
+		apiURL:  strings.TrimRight(apiURL, "/"),
+		token:   token,
+		subscr:  subscr,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		limiter: onlineconfbot.RateLimiterFromConfig(config, "/yamessenger"),
+		names:   make(map[string]string),
 	}, nil
 }
 
@@ -114,6 +123,8 @@ func (bot *YaMessengerBot) UpdatesProcessor(ctx context.Context) {
 				offset = update.UpdateID + 1
 			}
 
+			bot.rememberName(update.From)
+
 			bot.handleUpdate(ctx, update)
 		}
 
@@ -147,11 +158,23 @@ func (bot *YaMessengerBot) handleUpdate(ctx context.Context, update yaUpdate) {
 	case "/stop":
 		err = bot.unsubscribe(ctx, user)
 	case "/subscribers":
-		if onlineconfbot.IsAdmin(user) {
+		if onlineconfbot.IsAdmin(bot, user) {
 			err = bot.sendSubscribers(ctx, user)
 		} else {
 			log.Ctx(ctx).Warn().Str("user", user).Msg("non-admin attempted /subscribers command")
 		}
+	case "/failed":
+		if onlineconfbot.IsAdmin(bot, user) {
+			err = bot.sendFailed(ctx, user, args)
+		} else {
+			log.Ctx(ctx).Warn().Str("user", user).Msg("non-admin attempted /failed command")
+		}
+	case "/replay":
+		if onlineconfbot.IsAdmin(bot, user) {
+			err = bot.sendText(ctx, user, onlineconfbot.ReplayCommand(ctx, bot, user, args...))
+		} else {
+			log.Ctx(ctx).Warn().Str("user", user).Msg("non-admin attempted /replay command")
+		}
 	case "/help":
 		err = bot.sendHelp(user)
 	default:
@@ -220,14 +243,24 @@ func (bot *YaMessengerBot) sendSubscribers(ctx context.Context, user string) err
 	return bot.sendText(ctx, user, text.String())
 }
 
+func (bot *YaMessengerBot) sendFailed(ctx context.Context, user string, args []string) error {
+	reply, err := onlineconfbot.FailedCommand(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return bot.sendText(ctx, user, reply)
+}
+
 func (bot *YaMessengerBot) sendHelp(user string) error {
 	text := "Available commands:\n" +
 		"/start - Show subscribe prompt\n" +
 		"/subscribe [edit|view] - Subscribe to notifications\n" +
 		"/stop - Unsubscribe from notifications\n" +
 		"/help - Show this help"
-	if onlineconfbot.IsAdmin(user) {
+	if onlineconfbot.IsAdmin(bot, user) {
 		text += "\n/subscribers - Show subscribed users (admin only)"
+		text += "\n/failed list|fallback|retry <id|all>|drop <id|all> - Show, retry or drop failed deliveries, show fallback deliveries (admin only)"
+		text += "\n/replay <from-id> [<to-id>] [<user>] - Send notifications again (admin only)"
 	}
 	req := yaSendTextRequest{
 		Login: user,
@@ -236,8 +269,29 @@ func (bot *YaMessengerBot) sendHelp(user string) error {
 	return bot.doSendText(context.Background(), req)
 }
 
-func (bot *YaMessengerBot) Notify(ctx context.Context, user, link, text string) error {
-	return bot.sendText(ctx, user, text)
+func (bot *YaMessengerBot) Notify(ctx context.Context, user string, msg *onlineconfbot.Message) error {
+	return bot.sendText(ctx, user, msg.Text)
+}
+
+func (bot *YaMessengerBot) rememberName(sender yaSender) {
+	if sender.DisplayName == "" {
+		return
+	}
+
+	bot.namesMu.Lock()
+	bot.names[sender.Login] = sender.DisplayName
+	bot.namesMu.Unlock()
+}
+
+func (bot *YaMessengerBot) AuthorProfile(ctx context.Context, user string) (*onlineconfbot.AuthorProfile, error) {
+	bot.namesMu.Lock()
+	name, ok := bot.names[user]
+	bot.namesMu.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+	return &onlineconfbot.AuthorProfile{DisplayName: name}, nil
 }
 
 func (bot *YaMessengerBot) MentionLink(user string) string {
@@ -255,6 +309,12 @@ func (bot *YaMessengerBot) sendText(ctx context.Context, login, text string) err
 }
 
 func (bot *YaMessengerBot) doSendText(ctx context.Context, req yaSendTextRequest) error {
+	return bot.limiter.Do(ctx, func() error {
+		return bot.trySendText(ctx, req)
+	})
+}
+
+func (bot *YaMessengerBot) trySendText(ctx context.Context, req yaSendTextRequest) error {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("marshal sendText request: %w", err)
@@ -273,6 +333,10 @@ func (bot *YaMessengerBot) doSendText(ctx context.Context, req yaSendTextRequest
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &onlineconfbot.RateLimitedError{RetryAfter: onlineconfbot.ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("read sendText response: %w", err)
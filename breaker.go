@@ -0,0 +1,127 @@
+package onlineconfbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	breakerClosed   = "closed"    // BotAPI is polled normally
+	breakerOpen     = "open"      // too many consecutive failures, waiting before the next attempt
+	breakerHalfOpen = "half-open" // trying again after a pause
+
+	defaultBreakerThreshold = 5
+	defaultMaxBackoff       = 300 // seconds
+)
+
+// circuitBreaker tracks failures of the BotAPI poller and computes delays between attempts.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	state        string
+	failures     int
+	lastError    string
+	retryAt      time.Time
+	unauthorized bool
+}
+
+var pollerBreaker = &circuitBreaker{state: breakerClosed}
+
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != breakerClosed {
+		log.Info().Int("failures", cb.failures).Msg("BotAPI poller recovered")
+	}
+	cb.state = breakerClosed
+	cb.failures = 0
+	cb.lastError = ""
+	cb.unauthorized = false
+}
+
+// failure records the error and returns the delay before the next attempt: exponential backoff with jitter.
+// alert is true for the first of consecutive authentication failures.
+func (cb *circuitBreaker) failure(err error, interval time.Duration) (delay time.Duration, alert bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.lastError = err.Error()
+	if cb.failures >= config.GetInt("/onlineconf/breaker/threshold", defaultBreakerThreshold) {
+		cb.state = breakerOpen
+	}
+
+	delay = max(interval, time.Second)
+	maxDelay := time.Duration(config.GetInt("/onlineconf/breaker/max-backoff", defaultMaxBackoff)) * time.Second
+	for i := 1; i < cb.failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	delay = delay/2 + rand.N(delay/2+1)
+	cb.retryAt = time.Now().Add(delay)
+
+	if errors.Is(err, ErrUnauthorized) {
+		alert = !cb.unauthorized
+		cb.unauthorized = true
+	}
+	return delay, alert
+}
+
+// attempt switches an open breaker to half-open before the next try.
+func (cb *circuitBreaker) attempt() {
+	cb.mu.Lock()
+	if cb.state == breakerOpen {
+		cb.state = breakerHalfOpen
+	}
+	cb.mu.Unlock()
+}
+
+type breakerStatus struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"lastError,omitempty"`
+	RetryAt   *time.Time `json:"retryAt,omitempty"`
+}
+
+func (cb *circuitBreaker) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	cb.mu.Lock()
+	status := breakerStatus{
+		State:     cb.state,
+		Failures:  cb.failures,
+		LastError: cb.lastError,
+	}
+	if cb.failures > 0 {
+		retryAt := cb.retryAt
+		status.RetryAt = &retryAt
+	}
+	cb.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.State == breakerOpen {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]breakerStatus{"botapi": status})
+}
+
+// alertAdmins sends a service message to the admins of every messenger through that messenger.
+func alertAdmins(ctx context.Context, text string) {
+	msg := &Message{Text: text, Style: StylePlain}
+	for _, m := range messengers {
+		admins := m.admins()
+		if broadcaster, ok := m.raw.(Broadcaster); ok {
+			admins = broadcaster.Recipients()
+		}
+		for _, admin := range admins {
+			if err := m.bot.Notify(ctx, admin, msg); err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("messenger", m.name).Str("user", admin).Msg("failed to alert admin")
+			}
+		}
+	}
+}
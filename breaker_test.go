@@ -0,0 +1,147 @@
+package onlineconfbot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	errTimeout := errors.New("timeout")
+	type step struct {
+		fail      error // nil is a success
+		attempt   bool  // attempt is called before the step
+		wantState string
+		wantDelay time.Duration // delay before jitter, the actual one is in [wantDelay/2, wantDelay]
+		wantAlert bool
+	}
+
+	tests := []struct {
+		name     string
+		config   map[string]string
+		interval time.Duration
+		steps    []step
+	}{
+		{
+			name:     "backoff below threshold",
+			interval: 2 * time.Second,
+			steps: []step{
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 2 * time.Second},
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 4 * time.Second},
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 8 * time.Second},
+				{wantState: breakerClosed},
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 2 * time.Second},
+			},
+		},
+		{
+			name:     "open and half-open",
+			config:   map[string]string{"/onlineconf/breaker/threshold": "2"},
+			interval: time.Second,
+			steps: []step{
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: time.Second},
+				{fail: errTimeout, wantState: breakerOpen, wantDelay: 2 * time.Second},
+				{attempt: true, fail: errTimeout, wantState: breakerOpen, wantDelay: 4 * time.Second},
+				{attempt: true, wantState: breakerClosed},
+			},
+		},
+		{
+			name:     "max backoff",
+			config:   map[string]string{"/onlineconf/breaker/max-backoff": "5"},
+			interval: 0,
+			steps: []step{
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: time.Second},
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 2 * time.Second},
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 4 * time.Second},
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 5 * time.Second},
+				{fail: errTimeout, wantState: breakerOpen, wantDelay: 5 * time.Second},
+			},
+		},
+		{
+			name:     "unauthorized alert",
+			interval: time.Second,
+			steps: []step{
+				{fail: ErrUnauthorized, wantState: breakerClosed, wantDelay: time.Second, wantAlert: true},
+				{fail: ErrUnauthorized, wantState: breakerClosed, wantDelay: 2 * time.Second},
+				{fail: errTimeout, wantState: breakerClosed, wantDelay: 4 * time.Second},
+				{wantState: breakerClosed},
+				{fail: ErrUnauthorized, wantState: breakerClosed, wantDelay: time.Second, wantAlert: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.config)
+			cb := &circuitBreaker{state: breakerClosed}
+			for i, s := range tt.steps {
+				if s.attempt {
+					cb.attempt()
+					if cb.state != breakerHalfOpen {
+						t.Fatalf("step %d: state after attempt = %s, want %s", i, cb.state, breakerHalfOpen)
+					}
+				}
+				if s.fail == nil {
+					cb.success()
+				} else {
+					before := time.Now()
+					delay, alert := cb.failure(s.fail, tt.interval)
+					if delay < s.wantDelay/2 || delay > s.wantDelay {
+						t.Errorf("step %d: delay = %v, want between %v and %v", i, delay, s.wantDelay/2, s.wantDelay)
+					}
+					if cb.retryAt.Before(before.Add(delay)) || cb.retryAt.After(time.Now().Add(delay)) {
+						t.Errorf("step %d: retryAt is %v after the failure, want %v", i, cb.retryAt.Sub(before), delay)
+					}
+					if alert != s.wantAlert {
+						t.Errorf("step %d: alert = %v, want %v", i, alert, s.wantAlert)
+					}
+				}
+				if cb.state != s.wantState {
+					t.Errorf("step %d: state = %s, want %s", i, cb.state, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerStatus(t *testing.T) {
+	withConfig(t, map[string]string{"/onlineconf/breaker/threshold": "2"})
+	cb := &circuitBreaker{state: breakerClosed}
+
+	status := func() (int, breakerStatus) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		cb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		var body map[string]breakerStatus
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body["botapi"]
+	}
+
+	if code, s := status(); code != http.StatusOK || s.State != breakerClosed || s.Failures != 0 || s.LastError != "" || s.RetryAt != nil {
+		t.Errorf("status of a new breaker = %d %+v", code, s)
+	}
+
+	cb.failure(errors.New("timeout"), time.Second)
+	if code, s := status(); code != http.StatusOK || s.State != breakerClosed || s.Failures != 1 || s.LastError != "timeout" || s.RetryAt == nil || !s.RetryAt.Equal(cb.retryAt) {
+		t.Errorf("status after a failure = %d %+v", code, s)
+	}
+
+	cb.failure(errors.New("connection refused"), time.Second)
+	if code, s := status(); code != http.StatusServiceUnavailable || s.State != breakerOpen || s.Failures != 2 || s.LastError != "connection refused" {
+		t.Errorf("status of an open breaker = %d %+v", code, s)
+	}
+
+	cb.attempt()
+	if code, s := status(); code != http.StatusOK || s.State != breakerHalfOpen {
+		t.Errorf("status of a half-open breaker = %d %+v", code, s)
+	}
+
+	cb.success()
+	if code, s := status(); code != http.StatusOK || s.State != breakerClosed || s.Failures != 0 || s.LastError != "" || s.RetryAt != nil {
+		t.Errorf("status after recovery = %d %+v", code, s)
+	}
+}
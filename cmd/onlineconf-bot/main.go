@@ -0,0 +1,26 @@
+package main
+
+import (
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-bot/bots/email"
+	"github.com/onlineconf/onlineconf-bot/bots/matrix"
+	"github.com/onlineconf/onlineconf-bot/bots/mattermost"
+	"github.com/onlineconf/onlineconf-bot/bots/myteam"
+	"github.com/onlineconf/onlineconf-bot/bots/slack"
+	"github.com/onlineconf/onlineconf-bot/bots/telegram"
+	"github.com/onlineconf/onlineconf-bot/bots/webhook"
+	"github.com/onlineconf/onlineconf-bot/bots/yamessenger"
+)
+
+func main() {
+	onlineconfbot.MultiBotMain(map[string]onlineconfbot.BotConstructor{
+		"email":       onlineconfbot.Constructor(email.NewEmailBot),
+		"matrix":      onlineconfbot.Constructor(matrix.NewMatrixBot),
+		"mattermost":  onlineconfbot.Constructor(mattermost.NewMattermostBot),
+		"myteam":      onlineconfbot.Constructor(myteam.NewMyteamBot),
+		"slack":       onlineconfbot.Constructor(slack.NewSlackBot),
+		"telegram":    onlineconfbot.Constructor(telegram.NewTelegramBot),
+		"webhook":     onlineconfbot.Constructor(webhook.NewWebhookBot),
+		"yamessenger": onlineconfbot.Constructor(yamessenger.NewYaMessengerBot),
+	})
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-bot/bots/matrix"
+)
+
+func main() {
+	onlineconfbot.BotMain(matrix.NewMatrixBot)
+}
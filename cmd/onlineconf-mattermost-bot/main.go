@@ -1,7 +1,10 @@
 package main
 
-import onlineconfbot "github.com/onlineconf/onlineconf-bot"
+import (
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-bot/bots/mattermost"
+)
 
 func main() {
-	onlineconfbot.BotMain(NewMattermostBot)
+	onlineconfbot.BotMain(mattermost.NewMattermostBot)
 }
@@ -1,7 +1,10 @@
 package main
 
-import onlineconfbot "github.com/onlineconf/onlineconf-bot"
+import (
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-bot/bots/myteam"
+)
 
 func main() {
-	onlineconfbot.BotMain(NewMyteamBot)
+	onlineconfbot.BotMain(myteam.NewMyteamBot)
 }
@@ -1,7 +1,10 @@
 package main
 
-import onlineconfbot "github.com/onlineconf/onlineconf-bot"
+import (
+	onlineconfbot "github.com/onlineconf/onlineconf-bot"
+	"github.com/onlineconf/onlineconf-bot/bots/yamessenger"
+)
 
 func main() {
-	onlineconfbot.BotMain(NewYaMessengerBot)
+	onlineconfbot.BotMain(yamessenger.NewYaMessengerBot)
 }
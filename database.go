@@ -3,6 +3,8 @@ package onlineconfbot
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"net"
 	"strings"
 
@@ -15,12 +17,24 @@ type SubscriptionStorage interface {
 	Subscribers(context.Context) ([]Subscription, error)
 }
 
+// AccountStorage keeps messenger-specific addresses of accounts, e.g. Telegram chat IDs of OnlineConf users,
+// for messengers unable to address users by their account names. The storage passed to bots implements it.
+type AccountStorage interface {
+	LinkAccount(ctx context.Context, user, address string) error
+	UnlinkAccount(ctx context.Context, user string) error
+	AccountAddress(ctx context.Context, user string) (string, error)    // empty if the account is not linked
+	AddressAccount(ctx context.Context, address string) (string, error) // empty if the address is not linked
+}
+
+var ErrLastIDMoved = errors.New("lastid was changed concurrently")
+
 type database struct {
 	*sql.DB
+	messenger string // scopes subscriptions, the outbox and the sent-log, empty in a single-messenger process
 }
 
-type transaction struct {
-	*sql.Tx
+func databaseName() string {
+	return config.GetString("/database/base", strings.ReplaceAll(commandName, "-", "_"))
 }
 
 func openDatabase() (*database, error) {
@@ -30,7 +44,7 @@ func openDatabase() (*database, error) {
 	mysqlConfig.Passwd = config.GetString("/database/pass", "")
 	mysqlConfig.Net = "tcp"
 	mysqlConfig.Addr = net.JoinHostPort(config.GetString("/database/host", ""), config.GetString("/database/port", "3306"))
-	mysqlConfig.DBName = config.GetString("/database/base", defaultName)
+	mysqlConfig.DBName = databaseName()
 	mysqlConfig.Params = map[string]string{
 		"charset":   "utf8mb4",
 		"collation": "utf8mb4_general_ci",
@@ -41,19 +55,16 @@ func openDatabase() (*database, error) {
 		return nil, err
 	}
 
-	return &database{db}, nil
+	return &database{DB: db}, nil
 }
 
-func (db *database) BeginTx(ctx context.Context) (*transaction, error) {
-	tx, err := db.DB.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
-	}
-	return &transaction{tx}, nil
+// forMessenger returns the database scoped to the messenger, the lastid cursor is shared by all messengers.
+func (db *database) forMessenger(name string) *database {
+	return &database{DB: db.DB, messenger: name}
 }
 
-func (tx *transaction) GetLastID(ctx context.Context) (int, error) {
-	row := tx.QueryRowContext(ctx, "SELECT Value FROM lastid WHERE ID = 0 FOR UPDATE")
+func (db *database) GetLastID(ctx context.Context) (int, error) {
+	row := db.QueryRowContext(ctx, "SELECT Value FROM lastid WHERE ID = 0")
 	var lastID int
 	err := row.Scan(&lastID)
 	if err != nil {
@@ -62,18 +73,29 @@ func (tx *transaction) GetLastID(ctx context.Context) (int, error) {
 	return lastID, nil
 }
 
-func (tx *transaction) SetLastID(ctx context.Context, lastID int) error {
-	_, err := tx.ExecContext(ctx, "UPDATE lastid SET Value = ? WHERE ID = 0", lastID)
-	return err
+// AdvanceLastID moves the cursor from lastID to newLastID unless somebody else has moved it meanwhile.
+func (db *database) AdvanceLastID(ctx context.Context, lastID, newLastID int) error {
+	res, err := db.ExecContext(ctx, "UPDATE lastid SET Value = ? WHERE ID = 0 AND Value = ?", newLastID, lastID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLastIDMoved
+	}
+	return nil
 }
 
 func (db *database) Subscribe(ctx context.Context, user string, wo bool) error {
-	_, err := db.ExecContext(ctx, "INSERT INTO subscribe (User, WO) VALUES (?, ?) ON DUPLICATE KEY UPDATE WO=VALUES(WO)", user, wo)
+	_, err := db.ExecContext(ctx, "INSERT INTO subscribe (Messenger, User, WO) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE WO=VALUES(WO)", db.messenger, user, wo)
 	return err
 }
 
 func (db *database) Unsubscribe(ctx context.Context, user string) error {
-	_, err := db.ExecContext(ctx, "DELETE FROM subscribe WHERE User = ?", user)
+	_, err := db.ExecContext(ctx, "DELETE FROM subscribe WHERE Messenger = ? AND User = ?", db.messenger, user)
 	return err
 }
 
@@ -83,7 +105,7 @@ type Subscription struct {
 }
 
 func (db *database) Subscribers(ctx context.Context) ([]Subscription, error) {
-	rows, err := db.QueryContext(ctx, "SELECT User, WO FROM subscribe ORDER BY User")
+	rows, err := db.QueryContext(ctx, "SELECT User, WO FROM subscribe WHERE Messenger = ? ORDER BY User", db.messenger)
 	if err != nil {
 		return nil, err
 	}
@@ -111,9 +133,12 @@ func (db *database) FilterSubscribed(ctx context.Context, users map[string]strin
 			read = append(read, user)
 		}
 	}
+	if len(write) == 0 && len(read) == 0 {
+		return []string{}, nil
+	}
 	query := strings.Builder{}
-	query.WriteString("SELECT User FROM subscribe WHERE ")
-	bind := []interface{}{}
+	query.WriteString("SELECT User FROM subscribe WHERE Messenger = ? AND (")
+	bind := []interface{}{db.messenger}
 	if len(write) > 0 {
 		query.WriteString("User IN (")
 		for i, user := range write {
@@ -126,7 +151,7 @@ func (db *database) FilterSubscribed(ctx context.Context, users map[string]strin
 		query.WriteString(")")
 	}
 	if len(read) > 0 {
-		if len(bind) > 0 {
+		if len(write) > 0 {
 			query.WriteString(" OR ")
 		}
 		query.WriteString("(User IN (")
@@ -139,9 +164,7 @@ func (db *database) FilterSubscribed(ctx context.Context, users map[string]strin
 		}
 		query.WriteString(") AND NOT WO)")
 	}
-	if query.Len() == 0 {
-		return []string{}, nil
-	}
+	query.WriteString(")")
 	rows, err := db.QueryContext(ctx, query.String(), bind...)
 	if err != nil {
 		return nil, err
@@ -158,3 +181,280 @@ func (db *database) FilterSubscribed(ctx context.Context, users map[string]strin
 	}
 	return notifyUsers, nil
 }
+
+func (db *database) LinkAccount(ctx context.Context, user, address string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// an address belongs to a single account
+	if _, err := tx.ExecContext(ctx, "DELETE FROM account WHERE Messenger = ? AND Address = ? AND User <> ?", db.messenger, address, user); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO account (Messenger, User, Address) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE Address=VALUES(Address)", db.messenger, user, address); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *database) UnlinkAccount(ctx context.Context, user string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM account WHERE Messenger = ? AND User = ?", db.messenger, user)
+	return err
+}
+
+func (db *database) AccountAddress(ctx context.Context, user string) (string, error) {
+	var address string
+	err := db.QueryRowContext(ctx, "SELECT Address FROM account WHERE Messenger = ? AND User = ?", db.messenger, user).Scan(&address)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return address, err
+}
+
+func (db *database) AddressAccount(ctx context.Context, address string) (string, error) {
+	var user string
+	err := db.QueryRowContext(ctx, "SELECT User FROM account WHERE Messenger = ? AND Address = ?", db.messenger, address).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return user, err
+}
+
+// Delivery is a message to a single recipient recorded in the outbox.
+type Delivery struct {
+	ID            int64
+	Messenger     string
+	User          string
+	Origin        string // OnlineConf username of the recipient, empty if unknown
+	Style         string
+	Label         string // e.g. "backfill", kept on retries
+	Notifications []*Notification
+	Attempts      int
+	Error         string
+	Fallback      string // messenger and account the delivery was sent through instead
+	Created       string
+}
+
+// NotificationIDs returns IDs of all notifications of the delivery, several ones for a compact digest.
+func (delivery *Delivery) NotificationIDs() []int {
+	ids := make([]int, len(delivery.Notifications))
+	for i, notification := range delivery.Notifications {
+		ids[i] = notification.ID
+	}
+	return ids
+}
+
+// EnqueueDelivery stores the delivery in the outbox to be attempted after delay seconds and records its notifications
+// in the sent-log in the same transaction, so the delivery is never both lost and forgotten. delivery.ID is set.
+func (db *database) EnqueueDelivery(ctx context.Context, delivery *Delivery, delay int) error {
+	notifications, err := json.Marshal(delivery.Notifications)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO outbox (Messenger, User, Origin, Style, Label, Notifications, Attempts, NextAttempt, Error) VALUES (?, ?, ?, ?, ?, ?, ?, NOW() + INTERVAL ? SECOND, ?)",
+		db.messenger, delivery.User, delivery.Origin, delivery.Style, delivery.Label, notifications, delivery.Attempts, delay, delivery.Error)
+	if err != nil {
+		return err
+	}
+	if err := db.markSent(ctx, tx, delivery.User, delivery.NotificationIDs()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	delivery.ID, err = res.LastInsertId()
+	return err
+}
+
+// PendingBefore reports whether the user has pending deliveries older than the given one.
+func (db *database) PendingBefore(ctx context.Context, user string, id int64) (bool, error) {
+	var pending bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM outbox WHERE Messenger = ? AND User = ? AND State = 'pending' AND ID < ?)", db.messenger, user, id).Scan(&pending)
+	return pending, err
+}
+
+// DueDeliveries returns pending deliveries due to be attempted, only the oldest pending one of each user,
+// so messages to a user are delivered in order.
+func (db *database) DueDeliveries(ctx context.Context, limit int) ([]Delivery, error) {
+	rows, err := db.QueryContext(ctx, "SELECT ID, Messenger, User, Origin, Style, Label, Notifications, Attempts, Error FROM outbox WHERE Messenger = ? AND State = 'pending' AND NextAttempt <= NOW() "+
+		"AND NOT EXISTS (SELECT 1 FROM outbox AS earlier WHERE earlier.Messenger = outbox.Messenger AND earlier.User = outbox.User AND earlier.State = 'pending' AND earlier.ID < outbox.ID) "+
+		"ORDER BY ID LIMIT ?", db.messenger, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var delivery Delivery
+		var notifications []byte
+		err := rows.Scan(&delivery.ID, &delivery.Messenger, &delivery.User, &delivery.Origin, &delivery.Style, &delivery.Label, &notifications, &delivery.Attempts, &delivery.Error)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(notifications, &delivery.Notifications); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (db *database) DeleteDelivery(ctx context.Context, id int64) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM outbox WHERE ID = ?", id)
+	return err
+}
+
+func (db *database) RescheduleDelivery(ctx context.Context, delivery *Delivery, delay int) error {
+	_, err := db.ExecContext(ctx, "UPDATE outbox SET Attempts = ?, Error = ?, NextAttempt = NOW() + INTERVAL ? SECOND WHERE ID = ?",
+		delivery.Attempts, delivery.Error, delay, delivery.ID)
+	return err
+}
+
+// BuryDelivery moves the delivery to the dead-letter state, it is not retried anymore.
+func (db *database) BuryDelivery(ctx context.Context, delivery *Delivery) error {
+	_, err := db.ExecContext(ctx, "UPDATE outbox SET State = 'dead', Attempts = ?, Error = ? WHERE ID = ?",
+		delivery.Attempts, delivery.Error, delivery.ID)
+	return err
+}
+
+// DeadDeliveries returns deliveries of all messengers which ran out of attempts.
+func (db *database) DeadDeliveries(ctx context.Context) ([]Delivery, error) {
+	return db.deliveriesIn(ctx, "dead")
+}
+
+// FallbackDeliveries returns deliveries of all messengers which were sent through the fallback messenger.
+func (db *database) FallbackDeliveries(ctx context.Context) ([]Delivery, error) {
+	return db.deliveriesIn(ctx, "fallback")
+}
+
+func (db *database) deliveriesIn(ctx context.Context, state string) ([]Delivery, error) {
+	rows, err := db.QueryContext(ctx, "SELECT ID, Messenger, User, Style, Notifications, Attempts, Error, Fallback, DATE_FORMAT(Created, '%Y-%m-%d %H:%i:%s') FROM outbox WHERE State = ? ORDER BY ID", state)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var delivery Delivery
+		var notifications []byte
+		err := rows.Scan(&delivery.ID, &delivery.Messenger, &delivery.User, &delivery.Style, &notifications, &delivery.Attempts, &delivery.Error, &delivery.Fallback, &delivery.Created)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(notifications, &delivery.Notifications); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// FallBackDelivery records that the delivery was sent through the fallback messenger, the record is kept as history.
+func (db *database) FallBackDelivery(ctx context.Context, delivery *Delivery) error {
+	_, err := db.ExecContext(ctx, "UPDATE outbox SET State = 'fallback', Attempts = ?, Error = ?, Fallback = ? WHERE ID = ?",
+		delivery.Attempts, delivery.Error, delivery.Fallback, delivery.ID)
+	return err
+}
+
+// PruneFallbackDeliveries deletes history of fallback deliveries created more than ttlHours ago.
+func (db *database) PruneFallbackDeliveries(ctx context.Context, ttlHours int) (int64, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM outbox WHERE State = 'fallback' AND Created < NOW() - INTERVAL ? HOUR", ttlHours)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ReviveDeliveries returns dead deliveries to the outbox with a fresh number of attempts, id 0 means all of them.
+func (db *database) ReviveDeliveries(ctx context.Context, id int64) (int64, error) {
+	res, err := db.ExecContext(ctx, "UPDATE outbox SET State = 'pending', Attempts = 0, NextAttempt = NOW() WHERE State = 'dead' AND (? = 0 OR ID = ?)", id, id)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DropDeliveries deletes dead deliveries, id 0 means all of them.
+func (db *database) DropDeliveries(ctx context.Context, id int64) (int64, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM outbox WHERE State = 'dead' AND (? = 0 OR ID = ?)", id, id)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SentTo returns which of the users are recorded in the sent-log as having got the notification.
+func (db *database) SentTo(ctx context.Context, notificationID int, users []string) (map[string]bool, error) {
+	sent := map[string]bool{}
+	if len(users) == 0 {
+		return sent, nil
+	}
+	bind := []interface{}{notificationID, db.messenger}
+	for _, user := range users {
+		bind = append(bind, user)
+	}
+	query := "SELECT User FROM sent WHERE NotificationID = ? AND Messenger = ? AND User IN (?" + strings.Repeat(", ?", len(users)-1) + ")"
+	rows, err := db.QueryContext(ctx, query, bind...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user string
+		err := rows.Scan(&user)
+		if err != nil {
+			return nil, err
+		}
+		sent[user] = true
+	}
+	return sent, rows.Err()
+}
+
+// execer is *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// markSent records the notifications in the sent-log using the database or a transaction.
+// EnqueueDelivery writes the records together with the outbox row before Bot.Notify is called, so the sent-log
+// means "handed over to the outbox" rather than "sent": a batch repeated after a crash skips the delivery
+// and the outbox sends it at least once, possibly twice.
+func (db *database) markSent(ctx context.Context, exec execer, user string, notificationIDs []int) error {
+	if len(notificationIDs) == 0 {
+		return nil
+	}
+	bind := []interface{}{}
+	for _, id := range notificationIDs {
+		bind = append(bind, id, db.messenger, user)
+	}
+	query := "INSERT IGNORE INTO sent (NotificationID, Messenger, User) VALUES (?, ?, ?)" + strings.Repeat(", (?, ?, ?)", len(notificationIDs)-1)
+	_, err := exec.ExecContext(ctx, query, bind...)
+	return err
+}
+
+func (db *database) PruneSentLog(ctx context.Context, ttlHours int) (int64, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM sent WHERE Created < NOW() - INTERVAL ? HOUR", ttlHours)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ForgetSentAfter removes records of sent notifications with IDs above id,
+// after a reset of the OnlineConf database these IDs are reused by new notifications.
+func (db *database) ForgetSentAfter(ctx context.Context, id int) (int64, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM sent WHERE NotificationID > ?", id)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,203 @@
+package onlineconfbot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// sentLogDriver is a database/sql driver emulating the sent table for the queries of the sent-log.
+type sentLogDriver struct {
+	mu   sync.Mutex
+	rows map[sentKey]bool
+}
+
+type sentKey struct {
+	id        int64
+	messenger string
+	user      string
+}
+
+func (d *sentLogDriver) Open(string) (driver.Conn, error) { return &sentLogConn{d}, nil }
+
+type sentLogConn struct{ d *sentLogDriver }
+
+func (c *sentLogConn) Prepare(query string) (driver.Stmt, error) {
+	return &sentLogStmt{c.d, query}, nil
+}
+func (c *sentLogConn) Close() error { return nil }
+func (c *sentLogConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+type sentLogStmt struct {
+	d     *sentLogDriver
+	query string
+}
+
+func (s *sentLogStmt) Close() error  { return nil }
+func (s *sentLogStmt) NumInput() int { return -1 }
+
+func (s *sentLogStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "INSERT IGNORE INTO sent "):
+		for i := 0; i+2 < len(args); i += 3 {
+			s.d.rows[sentKey{args[i].(int64), args[i+1].(string), args[i+2].(string)}] = true
+		}
+		return driver.RowsAffected(len(args) / 3), nil
+	case strings.HasPrefix(s.query, "DELETE FROM sent WHERE NotificationID > ?"):
+		var n int64
+		for key := range s.d.rows {
+			if key.id > args[0].(int64) {
+				delete(s.d.rows, key)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("unexpected statement: %s", s.query)
+}
+
+func (s *sentLogStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	if !strings.HasPrefix(s.query, "SELECT User FROM sent WHERE NotificationID = ? AND Messenger = ? AND User IN (") {
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+	rows := &sentLogRows{}
+	for _, user := range args[2:] {
+		if s.d.rows[sentKey{args[0].(int64), args[1].(string), user.(string)}] {
+			rows.users = append(rows.users, user.(string))
+		}
+	}
+	sort.Strings(rows.users)
+	return rows, nil
+}
+
+type sentLogRows struct{ users []string }
+
+func (r *sentLogRows) Columns() []string { return []string{"User"} }
+func (r *sentLogRows) Close() error      { return nil }
+func (r *sentLogRows) Next(dest []driver.Value) error {
+	if len(r.users) == 0 {
+		return io.EOF
+	}
+	dest[0], r.users = r.users[0], r.users[1:]
+	return nil
+}
+
+var sentLogDrivers atomic.Int32
+
+// newSentLogDatabase returns a database scoped to the messenger whose sent table holds the given rows.
+func newSentLogDatabase(t *testing.T, messenger string, rows ...sentKey) *database {
+	d := &sentLogDriver{rows: make(map[sentKey]bool)}
+	for _, row := range rows {
+		d.rows[row] = true
+	}
+	name := fmt.Sprintf("sentlog-%d", sentLogDrivers.Add(1))
+	sql.Register(name, d)
+
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return (&database{DB: db}).forMessenger(messenger)
+}
+
+func TestNotifierUnsent(t *testing.T) {
+	db := newSentLogDatabase(t, "slack",
+		sentKey{10, "slack", "alice"},
+		sentKey{10, "email", "bob"},
+		sentKey{11, "slack", "bob"},
+	)
+	ntf := &Notifier{messenger: "slack", db: db}
+
+	tests := []struct {
+		id    int
+		users []string
+		want  []string
+	}{
+		{id: 10, users: []string{"alice", "bob", "carol"}, want: []string{"bob", "carol"}},
+		{id: 11, users: []string{"alice", "bob"}, want: []string{"alice"}},
+		{id: 12, users: []string{"alice", "bob"}, want: []string{"alice", "bob"}},
+		{id: 10, users: nil, want: nil},
+	}
+
+	for _, tt := range tests {
+		got, err := ntf.unsent(context.Background(), &Notification{ID: tt.id}, tt.users)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("unsent(%d, %q) = %q, want %q", tt.id, tt.users, got, tt.want)
+		}
+	}
+}
+
+func TestForgetSentAfter(t *testing.T) {
+	ctx := context.Background()
+	db := newSentLogDatabase(t, "slack",
+		sentKey{5, "slack", "alice"},
+		sentKey{10, "slack", "alice"},
+		sentKey{11, "email", "alice"},
+		sentKey{12, "slack", "bob"},
+	)
+
+	// BotAPI was reset to lastid 10, IDs above it will be reused by new notifications
+	n, err := db.ForgetSentAfter(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("ForgetSentAfter() = %d, want 2", n)
+	}
+
+	for _, tt := range []struct {
+		id   int
+		user string
+		want bool
+	}{
+		{id: 5, user: "alice", want: true},
+		{id: 10, user: "alice", want: true},
+		{id: 12, user: "bob", want: false},
+	} {
+		sent, err := db.SentTo(ctx, tt.id, []string{tt.user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent[tt.user] != tt.want {
+			t.Errorf("SentTo(%d, %s) = %v after ForgetSentAfter, want %v", tt.id, tt.user, sent[tt.user], tt.want)
+		}
+	}
+}
+
+func TestMarkSent(t *testing.T) {
+	ctx := context.Background()
+	db := newSentLogDatabase(t, "slack")
+
+	if err := db.markSent(ctx, db, "alice", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.markSent(ctx, db, "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := db.SentTo(ctx, 2, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sent["alice"] || sent["bob"] {
+		t.Errorf("SentTo() = %v after markSent for alice, want only alice", sent)
+	}
+}
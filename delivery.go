@@ -0,0 +1,374 @@
+package onlineconfbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRetryInterval = 10   // seconds between outbox scans
+	defaultRetryDelay    = 30   // delay before the first retry, doubled on each attempt
+	defaultRetryMaxDelay = 3600 // upper bound of the delay between attempts
+	defaultMaxAttempts   = 10
+	defaultConcurrency   = 4
+	deliveryQueueSize    = 100 // per worker
+	defaultSentLogTTL    = 168 // hours the sent-log entries are kept
+	sentLogPruneInterval = time.Hour
+	deliveryLease        = 300 // seconds a delivery being sent is not retried, unless the process dies it is done by then
+)
+
+// deliver queues the message to be sent by the worker pool of the notifier.
+func (ntf *Notifier) deliver(ctx context.Context, user string, msg *Message) {
+	if ntf.pool == nil {
+		ntf.pool = newDeliveryPool(config.GetInt("/delivery/concurrency", defaultConcurrency), ntf.send)
+	}
+	ntf.pool.submit(ctx, user, ntf.origins[user], msg)
+}
+
+// wait blocks until all queued messages are sent.
+func (ntf *Notifier) wait() {
+	if ntf.pool != nil {
+		ntf.pool.wait()
+		ntf.pool = nil
+	}
+}
+
+// send sends the message. The delivery is recorded in the outbox and the sent-log before sending, so it is neither
+// lost nor repeated by the batch if the process dies meanwhile, the outbox retries it instead. It is removed from
+// the outbox when sent, a failed delivery stays there to be retried later.
+// origin is the OnlineConf username of the recipient, used to find them in the fallback messenger.
+func (ntf *Notifier) send(ctx context.Context, user, origin string, msg *Message) {
+	if *dryRun {
+		if err := ntf.bot.Notify(ctx, user, msg); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("user", user).Msg("failed to send notification")
+		}
+		return
+	}
+
+	// the outbox must be updated even if sending was interrupted by the stop timeout
+	storeCtx := context.WithoutCancel(ctx)
+
+	delivery := newDelivery(user, origin, msg)
+	if err := ntf.db.EnqueueDelivery(storeCtx, delivery, deliveryLease); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("user", user).Msg("failed to store notification in outbox, sending it without a record")
+		if err := ntf.bot.Notify(ctx, user, msg); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("user", user).Msg("failed to send notification, it is lost")
+		}
+		return
+	}
+
+	// earlier failed messages to the user are retried first, this one waits for them in the outbox
+	if pending, err := ntf.db.PendingBefore(storeCtx, user, delivery.ID); err != nil || pending {
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("user", user).Msg("failed to check outbox, notification is left to it")
+		} else {
+			log.Ctx(ctx).Info().Str("user", user).Int64("delivery", delivery.ID).Msg("notification queued behind earlier failed deliveries")
+		}
+		delivery.Error = "queued behind earlier deliveries"
+		if err := ntf.db.RescheduleDelivery(storeCtx, delivery, 0); err != nil {
+			log.Ctx(ctx).Error().Err(err).Int64("delivery", delivery.ID).Msg("failed to reschedule notification")
+		}
+		return
+	}
+
+	err := ntf.bot.Notify(ctx, user, msg)
+	if err == nil {
+		if err := ntf.db.DeleteDelivery(storeCtx, delivery.ID); err != nil {
+			log.Ctx(ctx).Error().Err(err).Int64("delivery", delivery.ID).Msg("failed to remove sent notification from outbox, it will be sent again")
+		}
+		return
+	}
+
+	log.Ctx(ctx).Error().Err(err).Str("user", user).Msg("failed to send notification")
+	delivery.Attempts = 1
+	delivery.Error = err.Error()
+	if err := ntf.db.RescheduleDelivery(storeCtx, delivery, retryDelay(delivery.Attempts)); err != nil {
+		log.Ctx(ctx).Error().Err(err).Int64("delivery", delivery.ID).Msg("failed to reschedule notification")
+	}
+}
+
+// newDelivery prepares the message to be stored in the outbox.
+func newDelivery(user, origin string, msg *Message) *Delivery {
+	delivery := &Delivery{
+		User:          user,
+		Origin:        origin,
+		Style:         msg.Style,
+		Label:         msg.Label,
+		Notifications: make([]*Notification, len(msg.Notifications)),
+	}
+	for i, notification := range msg.Notifications {
+		delivery.Notifications[i] = notification.Redacted()
+	}
+	return delivery
+}
+
+// unsent filters out users who have already got the notification.
+func (ntf *Notifier) unsent(ctx context.Context, notification *Notification, users []string) ([]string, error) {
+	sent, err := ntf.db.SentTo(ctx, notification.ID, users)
+	if err != nil || len(sent) == 0 {
+		return users, err
+	}
+
+	ret := make([]string, 0, len(users))
+	for _, user := range users {
+		if sent[user] {
+			log.Ctx(ctx).Info().Int("id", notification.ID).Str("user", user).Msg("notification has already been sent")
+			continue
+		}
+		ret = append(ret, user)
+	}
+	return ret, nil
+}
+
+// deliveryPool sends messages in parallel. Messages to the same recipient are always
+// handled by the same worker, so they are delivered in order.
+type deliveryPool struct {
+	queues []chan deliveryJob
+	wg     sync.WaitGroup
+}
+
+type deliveryJob struct {
+	ctx    context.Context
+	user   string
+	origin string
+	msg    *Message
+}
+
+func newDeliveryPool(workers int, send func(context.Context, string, string, *Message)) *deliveryPool {
+	pool := &deliveryPool{queues: make([]chan deliveryJob, max(workers, 1))}
+	for i := range pool.queues {
+		queue := make(chan deliveryJob, deliveryQueueSize)
+		pool.queues[i] = queue
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range queue {
+				send(job.ctx, job.user, job.origin, job.msg)
+			}
+		}()
+	}
+	return pool
+}
+
+func (pool *deliveryPool) submit(ctx context.Context, user, origin string, msg *Message) {
+	hash := fnv.New32a()
+	hash.Write([]byte(user))
+	pool.queues[hash.Sum32()%uint32(len(pool.queues))] <- deliveryJob{ctx, user, origin, msg}
+}
+
+func (pool *deliveryPool) wait() {
+	for _, queue := range pool.queues {
+		close(queue)
+	}
+	pool.wg.Wait()
+}
+
+// retryDelay returns seconds to wait before the next attempt: exponential backoff with an upper bound.
+func retryDelay(attempts int) int {
+	return backoff(attempts, config.GetInt("/delivery/retry-delay", defaultRetryDelay), config.GetInt("/delivery/retry-max-delay", defaultRetryMaxDelay))
+}
+
+// backoff doubles delay after each of the failed attempts, up to maxDelay.
+func backoff(attempts, delay, maxDelay int) int {
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// exhausted reports whether a delivery which failed attempts times is given up on and kept as dead.
+func exhausted(attempts, maxAttempts int) bool {
+	return attempts >= maxAttempts
+}
+
+// retryDeliveries periodically resends deliveries from the outbox through their messengers
+// until they succeed or run out of attempts. It also prunes the sent-log.
+func retryDeliveries(ctx context.Context, ms []*messenger) {
+	var pruned time.Time
+	for {
+		timer := time.NewTimer(time.Duration(config.GetInt("/delivery/retry-interval", defaultRetryInterval)) * time.Second)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			for _, m := range ms {
+				if err := retryDue(ctx, m); err != nil && !errors.Is(err, context.Canceled) {
+					log.Ctx(ctx).Error().Err(err).Str("messenger", m.name).Msg("failed to retry deliveries")
+				}
+			}
+
+			if time.Since(pruned) >= sentLogPruneInterval {
+				ttl := config.GetInt("/delivery/sent-log-ttl", defaultSentLogTTL)
+				n, err := db.PruneSentLog(ctx, ttl)
+				if err == nil {
+					log.Ctx(ctx).Debug().Int64("deleted", n).Msg("sent-log pruned")
+					n, err = db.PruneFallbackDeliveries(ctx, ttl)
+				}
+				if err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("failed to prune sent-log")
+				} else {
+					log.Ctx(ctx).Debug().Int64("deleted", n).Msg("fallback history pruned")
+					pruned = time.Now()
+				}
+			}
+		}
+	}
+}
+
+// retryDue attempts due deliveries of the messenger. Only the oldest pending delivery of each user is due,
+// so the outbox is scanned again while deliveries leave it, letting the next ones of their users follow.
+func retryDue(ctx context.Context, m *messenger) error {
+	for {
+		progressed, err := retryDueOnce(ctx, m)
+		if err != nil || !progressed {
+			return err
+		}
+	}
+}
+
+func retryDueOnce(ctx context.Context, m *messenger) (progressed bool, err error) {
+	deliveries, err := m.db.DueDeliveries(ctx, config.GetInt("/onlineconf/batch-size", 100))
+	if err != nil {
+		return false, err
+	}
+
+	notifier := newNotifier(m)
+	maxAttempts := config.GetInt("/delivery/max-attempts", defaultMaxAttempts)
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		logger := log.Ctx(ctx).With().Int64("delivery", delivery.ID).Str("user", delivery.User).Logger()
+
+		for _, notification := range delivery.Notifications {
+			notifier.prepare(ctx, notification)
+		}
+
+		err := m.bot.Notify(ctx, delivery.User, newMessage(delivery.Style, delivery.Label, delivery.Notifications))
+		if errors.Is(err, context.Canceled) {
+			return progressed, err
+		}
+
+		if err == nil {
+			logger.Info().Int("attempts", delivery.Attempts+1).Msg("notification delivered on retry")
+			err = m.db.DeleteDelivery(ctx, delivery.ID)
+			progressed = true
+		} else {
+			delivery.Attempts++
+			delivery.Error = err.Error()
+			if fallback := fallbackFor(m, delivery); fallback != nil && sendFallback(ctx, fallback, m, delivery) {
+				logger.Warn().Err(err).Str("fallback", delivery.Fallback).Msg("notification delivered through the fallback messenger")
+				err = m.db.FallBackDelivery(ctx, delivery)
+				progressed = true
+			} else if exhausted(delivery.Attempts, maxAttempts) {
+				logger.Error().Err(err).Int("attempts", delivery.Attempts).Msg("giving up on notification delivery")
+				err = m.db.BuryDelivery(ctx, delivery)
+				progressed = true
+			} else {
+				logger.Warn().Err(err).Int("attempts", delivery.Attempts).Msg("notification delivery retry failed")
+				err = m.db.RescheduleDelivery(ctx, delivery, retryDelay(delivery.Attempts))
+			}
+		}
+		if err != nil {
+			return progressed, err
+		}
+	}
+
+	return progressed, nil
+}
+
+// FailedUsage describes arguments of the failed deliveries command.
+const FailedUsage = "failed list | failed fallback | failed retry <id|all> | failed drop <id|all>"
+
+// FailedCommand handles the admin command inspecting failed deliveries and returns a plain text reply.
+// Bots must check the user is an admin before calling it.
+func FailedCommand(ctx context.Context, args ...string) (string, error) {
+	if len(args) == 0 {
+		return "Usage: " + FailedUsage, nil
+	}
+
+	switch args[0] {
+	case "list", "fallback":
+		if len(args) != 1 {
+			break
+		}
+
+		var deliveries []Delivery
+		var err error
+		if args[0] == "list" {
+			deliveries, err = db.DeadDeliveries(ctx)
+		} else {
+			deliveries, err = db.FallbackDeliveries(ctx)
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(deliveries) == 0 && args[0] == "list" {
+			return "No failed deliveries", nil
+		} else if len(deliveries) == 0 {
+			return "No fallback deliveries", nil
+		}
+
+		return listDeliveries(deliveries), nil
+
+	case "retry", "drop":
+		if len(args) != 2 {
+			break
+		}
+
+		var id int64
+		if args[1] != "all" {
+			var err error
+			id, err = strconv.ParseInt(args[1], 10, 64)
+			if err != nil || id <= 0 {
+				return "Invalid delivery id: " + args[1], nil
+			}
+		}
+
+		if args[0] == "retry" {
+			n, err := db.ReviveDeliveries(ctx, id)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d failed deliveries scheduled for retry", n), nil
+		}
+
+		n, err := db.DropDeliveries(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d failed deliveries dropped", n), nil
+	}
+
+	return "Usage: " + FailedUsage, nil
+}
+
+// listDeliveries renders deliveries for the failed command, one line each.
+func listDeliveries(deliveries []Delivery) string {
+	text := strings.Builder{}
+	for _, delivery := range deliveries {
+		paths := make([]string, len(delivery.Notifications))
+		for i, notification := range delivery.Notifications {
+			paths[i] = notification.Path + " (" + strconv.Itoa(notification.ID) + ")"
+		}
+		user := delivery.User
+		if delivery.Messenger != "" {
+			user += " (" + delivery.Messenger + ")"
+		}
+		fmt.Fprintf(&text, "#%d %s to %s: %s (%d attempts): %s",
+			delivery.ID, delivery.Created, user, strings.Join(paths, ", "), delivery.Attempts, delivery.Error)
+		if delivery.Fallback != "" {
+			fmt.Fprintf(&text, ", sent to %s instead", delivery.Fallback)
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
@@ -0,0 +1,206 @@
+package onlineconfbot
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    int
+		maxDelay int
+		want     int
+	}{
+		{attempts: 0, delay: 30, maxDelay: 3600, want: 30},
+		{attempts: 1, delay: 30, maxDelay: 3600, want: 30},
+		{attempts: 2, delay: 30, maxDelay: 3600, want: 60},
+		{attempts: 5, delay: 30, maxDelay: 3600, want: 480},
+		{attempts: 8, delay: 30, maxDelay: 3600, want: 3600},
+		{attempts: 1000, delay: 30, maxDelay: 3600, want: 3600},
+		{attempts: 1, delay: 100, maxDelay: 50, want: 50},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.attempts, tt.delay, tt.maxDelay); got != tt.want {
+			t.Errorf("backoff(%d, %d, %d) = %d, want %d", tt.attempts, tt.delay, tt.maxDelay, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if got := retryDelay(1); got != defaultRetryDelay {
+		t.Errorf("retryDelay(1) = %d, want %d", got, defaultRetryDelay)
+	}
+	if got := retryDelay(100); got != defaultRetryMaxDelay {
+		t.Errorf("retryDelay(100) = %d, want %d", got, defaultRetryMaxDelay)
+	}
+
+	withConfig(t, map[string]string{"/delivery/retry-delay": "10", "/delivery/retry-max-delay": "25"})
+	for attempts, want := range map[int]int{1: 10, 2: 20, 3: 25, 10: 25} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %d, want %d", attempts, got, want)
+		}
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	mattermost := &messenger{name: "mattermost"}
+	email := &messenger{name: "email"}
+	prev := messengers
+	messengers = []*messenger{mattermost, email}
+	t.Cleanup(func() { messengers = prev })
+
+	fallbackConfig := map[string]string{"/delivery/fallback/messenger": "email", "/delivery/fallback/after": "3"}
+	pathsConfig := map[string]string{"/delivery/fallback/messenger": "email", "/delivery/fallback/paths": "/app/db"}
+
+	tests := []struct {
+		name         string
+		config       map[string]string
+		from         *messenger
+		origin       string
+		path         string
+		attempts     int
+		wantFallback *messenger
+		wantDead     bool
+	}{
+		{name: "retried", attempts: 1},
+		{name: "dead without fallback", attempts: defaultMaxAttempts, wantDead: true},
+		{name: "before fallback", config: fallbackConfig, origin: "alice", attempts: 2},
+		{name: "fallback", config: fallbackConfig, origin: "alice", attempts: 3, wantFallback: email},
+		{name: "fallback instead of dead", config: fallbackConfig, origin: "alice", attempts: defaultMaxAttempts, wantFallback: email, wantDead: true},
+		{name: "no fallback to itself", config: fallbackConfig, from: email, origin: "alice", attempts: defaultMaxAttempts, wantDead: true},
+		{name: "no fallback for unknown origin", config: fallbackConfig, attempts: defaultMaxAttempts, wantDead: true},
+		{name: "fallback path", config: pathsConfig, origin: "alice", path: "/app/db/host", attempts: 3, wantFallback: email},
+		{name: "other path", config: pathsConfig, origin: "alice", path: "/app/cache", attempts: defaultMaxAttempts, wantDead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.config)
+			from := tt.from
+			if from == nil {
+				from = mattermost
+			}
+			path := tt.path
+			if path == "" {
+				path = "/app/db/host"
+			}
+			delivery := &Delivery{Origin: tt.origin, Attempts: tt.attempts, Notifications: []*Notification{{Path: path}}}
+
+			if got := fallbackFor(from, delivery); got != tt.wantFallback {
+				t.Errorf("fallbackFor() = %v, want %v", got, tt.wantFallback)
+			}
+			if got := exhausted(tt.attempts, config.GetInt("/delivery/max-attempts", defaultMaxAttempts)); got != tt.wantDead {
+				t.Errorf("exhausted(%d) = %v, want %v", tt.attempts, got, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestListDeliveries(t *testing.T) {
+	deliveries := []Delivery{
+		{
+			ID:            7,
+			User:          "alice",
+			Notifications: []*Notification{{ID: 10, Path: "/app/db"}},
+			Attempts:      10,
+			Error:         "timeout",
+			Created:       "2024-01-05 12:00:00",
+		},
+		{
+			ID:            8,
+			Messenger:     "slack",
+			User:          "bob@example.com",
+			Notifications: []*Notification{{ID: 11, Path: "/app/a"}, {ID: 12, Path: "/app/b"}},
+			Attempts:      3,
+			Error:         "channel_not_found",
+			Fallback:      "email:bob@example.com",
+			Created:       "2024-01-05 12:01:00",
+		},
+	}
+	want := "#7 2024-01-05 12:00:00 to alice: /app/db (10) (10 attempts): timeout\n" +
+		"#8 2024-01-05 12:01:00 to bob@example.com (slack): /app/a (11), /app/b (12) (3 attempts): channel_not_found, sent to email:bob@example.com instead\n"
+	if got := listDeliveries(deliveries); got != want {
+		t.Errorf("listDeliveries() = %q, want %q", got, want)
+	}
+}
+
+func TestFailedCommandArguments(t *testing.T) {
+	usage := "Usage: " + FailedUsage
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: usage},
+		{args: []string{"unknown"}, want: usage},
+		{args: []string{"list", "extra"}, want: usage},
+		{args: []string{"retry"}, want: usage},
+		{args: []string{"drop", "1", "2"}, want: usage},
+		{args: []string{"retry", "x"}, want: "Invalid delivery id: x"},
+		{args: []string{"drop", "0"}, want: "Invalid delivery id: 0"},
+	}
+
+	for _, tt := range tests {
+		got, err := FailedCommand(context.Background(), tt.args...)
+		if err != nil || got != tt.want {
+			t.Errorf("FailedCommand(%q) = %q, %v, want %q", tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestDeliveryPoolOrder(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	pool := newDeliveryPool(4, func(ctx context.Context, user, origin string, msg *Message) {
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		mu.Lock()
+		received[user] = append(received[user], msg.Notifications[0].ID)
+		mu.Unlock()
+	})
+
+	users := []string{"alice", "bob", "carol", "dave", "eve", "frank", "grace", "heidi"}
+	for id := 1; id <= 400; id++ {
+		user := users[id%len(users)]
+		pool.submit(context.Background(), user, user, &Message{Notifications: []*Notification{{ID: id}}})
+	}
+	pool.wait()
+
+	for _, user := range users {
+		ids := received[user]
+		if len(ids) != 400/len(users) {
+			t.Errorf("%s received %d messages, want %d", user, len(ids), 400/len(users))
+		}
+		if !sort.IntsAreSorted(ids) {
+			t.Errorf("%s received messages out of order: %v", user, ids)
+		}
+	}
+}
+
+// The lastid cursor is advanced after flush returns, so flush must not return before every message is sent.
+func TestNotifierFlushWaitsForSends(t *testing.T) {
+	var sent atomic.Int32
+	ntf := &Notifier{digests: make(map[string]*Message)}
+	ntf.pool = newDeliveryPool(2, func(ctx context.Context, user, origin string, msg *Message) {
+		time.Sleep(10 * time.Millisecond)
+		sent.Add(1)
+	})
+
+	for i := 0; i < 10; i++ {
+		ntf.deliver(context.Background(), "user"+strconv.Itoa(i), &Message{})
+	}
+	ntf.flush(context.Background())
+
+	if n := sent.Load(); n != 10 {
+		t.Errorf("flush returned after %d of 10 messages were sent", n)
+	}
+	if ntf.pool != nil {
+		t.Error("flush kept the finished pool")
+	}
+}
@@ -0,0 +1,80 @@
+package onlineconfbot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultFallbackAfter = 3
+
+// fallbackFor returns the messenger the failing delivery should be sent through instead, or nil.
+// Deliveries fall back after /delivery/fallback/after failed attempts to /delivery/fallback/messenger,
+// optionally only for parameters under /delivery/fallback/paths.
+func fallbackFor(m *messenger, delivery *Delivery) *messenger {
+	name := config.GetString("/delivery/fallback/messenger", "")
+	if name == "" || name == m.name || delivery.Origin == "" ||
+		delivery.Attempts < config.GetInt("/delivery/fallback/after", defaultFallbackAfter) {
+		return nil
+	}
+
+	if paths := config.GetStrings("/delivery/fallback/paths", nil); len(paths) > 0 && !underAny(delivery.Notifications, paths) {
+		return nil
+	}
+
+	for _, fallback := range messengers {
+		if fallback.name == name {
+			return fallback
+		}
+	}
+	return nil
+}
+
+func underAny(notifications []*Notification, paths []string) bool {
+	for _, notification := range notifications {
+		for _, path := range paths {
+			if notification.Path == path || strings.HasPrefix(notification.Path, strings.TrimSuffix(path, "/")+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendFallback sends the delivery of m through the fallback messenger to the account of the same OnlineConf user,
+// if the account is subscribed there. On success delivery.Fallback is set.
+func sendFallback(ctx context.Context, fallback, m *messenger, delivery *Delivery) bool {
+	notifier := newNotifier(fallback)
+	user := notifier.mapUser(delivery.Origin)
+
+	// "rw" matches both subscription modes, the access was checked when the delivery was created
+	subscribed, err := fallback.db.FilterSubscribed(ctx, map[string]string{user: "rw"})
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("messenger", fallback.name).Str("user", user).Msg("failed to check fallback subscription")
+		return false
+	} else if len(subscribed) == 0 {
+		log.Ctx(ctx).Debug().Str("messenger", fallback.name).Str("user", user).Msg("user is not subscribed in the fallback messenger")
+		return false
+	}
+
+	notifications := make([]*Notification, len(delivery.Notifications))
+	for i, notification := range delivery.Notifications {
+		copied := *notification
+		notifier.prepare(ctx, &copied)
+		notifications[i] = &copied
+	}
+
+	label := "not delivered to " + m.name
+	if delivery.Label != "" {
+		label = delivery.Label + ", " + label
+	}
+	msg := newMessage(notifier.styleFor(ctx, user), label, notifications)
+	if err := fallback.bot.Notify(ctx, user, msg); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("messenger", fallback.name).Str("user", user).Msg("fallback delivery failed")
+		return false
+	}
+
+	delivery.Fallback = fallback.name + ":" + user
+	return true
+}
@@ -3,7 +3,9 @@ module github.com/onlineconf/onlineconf-bot
 go 1.23
 
 require (
+	github.com/colinmarc/cdb v0.0.0-20190223170904-60f317823f70
 	github.com/go-sql-driver/mysql v1.8.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/mail-ru-im/bot-golang v0.0.0-20200509193603-2c56a20fca87
 	github.com/mattermost/mattermost-server/v6 v6.7.2
 	github.com/onlineconf/onlineconf-go v1.2.0
@@ -13,14 +15,12 @@ require (
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/blang/semver v3.5.1+incompatible // indirect
-	github.com/colinmarc/cdb v0.0.0-20190223170904-60f317823f70 // indirect
 	github.com/dustin/go-humanize v1.0.0 // indirect
 	github.com/dyatlov/go-opengraph v0.0.0-20210112100619-dae8665a5b09 // indirect
 	github.com/francoispqt/gojay v1.2.13 // indirect
 	github.com/fsnotify/fsnotify v1.5.1 // indirect
 	github.com/go-asn1-ber/asn1-ber v1.5.3 // indirect
 	github.com/google/uuid v1.3.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/graph-gophers/graphql-go v1.3.0 // indirect
 	github.com/hako/durafmt v0.0.0-20210608085754-5c1018a4e16b // indirect
 	github.com/josharian/intern v1.0.0 // indirect
@@ -0,0 +1,93 @@
+package onlineconfbot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultLeaderInterval = 5 // seconds
+
+var errLeadershipLost = errors.New("leadership lost")
+
+// acquireLeadership blocks until this instance holds the MySQL advisory lock, i.e. becomes the leader.
+// The lock belongs to a dedicated connection and is released by the server as soon as the connection breaks,
+// so a standby takes over within /ha/interval seconds after the leader dies.
+// The returned context is cancelled when the leadership is lost.
+func acquireLeadership(ctx context.Context) (context.Context, error) {
+	name := config.GetString("/ha/lock-name", databaseName()+".leader")
+	interval := time.Duration(config.GetInt("/ha/interval", defaultLeaderInterval)) * time.Second
+	if interval <= 0 {
+		log.Ctx(ctx).Warn().Dur("interval", interval).Msg("invalid /ha/interval, using default")
+		interval = defaultLeaderInterval * time.Second
+	}
+
+	for {
+		var acquired sql.NullInt64 // NULL if GET_LOCK has failed without waiting, e.g. killed
+		conn, err := db.Conn(ctx)
+		if err == nil {
+			err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(interval/time.Second)).Scan(&acquired)
+			if err == nil && acquired.Valid && acquired.Int64 == 1 {
+				log.Ctx(ctx).Info().Str("lock", name).Msg("became the leader")
+				leaderCtx, cancel := context.WithCancelCause(ctx)
+				go watchLeadership(leaderCtx, cancel, conn, name, interval)
+				return leaderCtx, nil
+			}
+			conn.Close()
+		}
+
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		} else if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to acquire leader lock")
+		} else if acquired.Valid && acquired.Int64 == 0 && interval > 0 {
+			log.Ctx(ctx).Debug().Str("lock", name).Msg("another instance is the leader")
+			continue // GET_LOCK has already waited for the interval
+		} else {
+			log.Ctx(ctx).Error().Str("lock", name).Msg("failed to acquire leader lock, GET_LOCK returned NULL")
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// watchLeadership checks the lock is still held by the connection and releases it when ctx is done.
+func watchLeadership(ctx context.Context, cancel context.CancelCauseFunc, conn *sql.Conn, name string, interval time.Duration) {
+	defer conn.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancelRelease := context.WithTimeout(context.Background(), interval)
+			if _, err := conn.ExecContext(releaseCtx, "DO RELEASE_LOCK(?)", name); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msg("failed to release leader lock")
+			}
+			cancelRelease()
+			return
+		case <-ticker.C:
+			checkCtx, cancelCheck := context.WithTimeout(ctx, interval)
+			var held sql.NullBool
+			err := conn.QueryRowContext(checkCtx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", name).Scan(&held)
+			cancelCheck()
+			if err != nil && ctx.Err() == nil {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to check leader lock")
+				cancel(errLeadershipLost)
+			} else if err == nil && !held.Bool {
+				log.Ctx(ctx).Error().Str("lock", name).Msg("leader lock is not held anymore")
+				cancel(errLeadershipLost)
+			}
+		}
+	}
+}
@@ -2,11 +2,14 @@ package onlineconfbot
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	stdlog "log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/onlineconf/onlineconf-go"
@@ -18,11 +21,62 @@ var commandName = filepath.Base(flag.CommandLine.Name())
 var configDir = flag.String("config-dir", "", "onlineconf configuration files directory")
 var configModule = flag.String("config-module", commandName, "onlineconf module name")
 var logLevel = flag.String("log-level", "debug", "log level")
+var replayFrom = flag.Int("replay-from", 0, "send notifications starting with this ID again and exit")
+var replayTo = flag.Int("replay-to", 0, "last notification ID to replay (default: lastid cursor)")
+var replayUser = flag.String("replay-user", "", "replay only to this messenger account (default: current subscribers)")
+var dryRun = flag.Bool("dry-run", false, "write deliveries to stdout as JSON lines instead of sending them, lastid is not advanced")
+var failedMode = flag.Bool("failed", false, "run a failed deliveries command given as arguments ("+FailedUsage+") and exit")
 
 var config *onlineconf.Module
 var db *database
 
 func BotMain[botType Bot](newBot func(*onlineconf.Module, SubscriptionStorage) (botType, error)) {
+	botMain(func() error {
+		if config.GetString("/delivery/fallback/messenger", "") != "" {
+			return errors.New("/delivery/fallback/messenger requires onlineconf-bot running several messengers")
+		}
+		bot, err := Constructor(newBot)(config, db)
+		if err != nil {
+			return err
+		}
+		addMessenger("", bot, db)
+		return nil
+	})
+}
+
+// MultiBotMain runs bots of several messengers in one process sharing the lastid cursor.
+// Bots listed in /messengers are started, each of them keeps its own subscriptions.
+func MultiBotMain(newBots map[string]BotConstructor) {
+	botMain(func() error {
+		names := config.GetStrings("/messengers", nil)
+		if len(names) == 0 {
+			return errors.New("please specify messengers to run using /messengers")
+		}
+		for _, name := range names {
+			newBot, ok := newBots[name]
+			if !ok {
+				return fmt.Errorf("unknown messenger %q", name)
+			}
+			storage := db.forMessenger(name)
+			bot, err := newBot(config, storage)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			addMessenger(name, bot, storage)
+		}
+		return nil
+	})
+}
+
+func addMessenger(name string, bot Bot, storage *database) {
+	m := &messenger{name: name, bot: bot, raw: bot, db: storage}
+	if *dryRun {
+		m.bot = newDryRunBot(name, bot, os.Stdout)
+	}
+	messengers = append(messengers, m)
+}
+
+func botMain(newBots func() error) {
 	flag.Parse()
 
 	stdlog.SetFlags(0)
@@ -47,10 +101,25 @@ func BotMain[botType Bot](newBot func(*onlineconf.Module, SubscriptionStorage) (
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to ping database")
 	}
-	bot, err := newBot(config, db)
-	if err != nil {
+
+	if *failedMode {
+		reply, err := FailedCommand(context.Background(), flag.Args()...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed deliveries command failed")
+		}
+		fmt.Println(strings.TrimSuffix(reply, "\n"))
+		return
+	}
+
+	if err := newBots(); err != nil {
 		log.Fatal().Err(err).Msg("failed to initialize bot")
 	}
+	if _, err := backfillSince(); err != nil {
+		log.Fatal().Err(err).Msg("invalid configuration")
+	}
+	if *dryRun {
+		log.Warn().Msg("dry-run mode, notifications are not sent")
+	}
 
 	ctx, cancel := context.WithCancel(log.Logger.WithContext(context.Background()))
 	defer cancel()
@@ -68,9 +137,53 @@ func BotMain[botType Bot](newBot func(*onlineconf.Module, SubscriptionStorage) (
 		cancel()
 	}()
 
-	go bot.UpdatesProcessor(ctx)
+	if *replayFrom != 0 {
+		n, err := replay(ctx, messengers, *replayFrom, *replayTo, *replayUser)
+		if err != nil {
+			log.Fatal().Err(err).Int("replayed", n).Msg("replay failed")
+		}
+		log.Info().Int("replayed", n).Msg("replay finished")
+		return
+	}
+
 	go probeServer.Run(ctx)
-	notificationsReceiver(ctx, bot)
+
+	leaderCtx := ctx
+	if config.GetBool("/ha/enabled", false) && !*dryRun {
+		var leaderOnly []*messenger
+		for _, m := range messengers {
+			if shared, ok := m.bot.(SharedUpdatesProcessor); ok && shared.SharedUpdates() {
+				go m.bot.UpdatesProcessor(ctx)
+			} else {
+				leaderOnly = append(leaderOnly, m)
+			}
+		}
+
+		log.Info().Msg("waiting for leadership")
+		leaderCtx, err = acquireLeadership(ctx)
+		if err != nil {
+			log.Info().Msg("onlineconf-bot stopped")
+			return
+		}
+
+		for _, m := range leaderOnly {
+			go m.bot.UpdatesProcessor(leaderCtx)
+		}
+	} else {
+		for _, m := range messengers {
+			go m.bot.UpdatesProcessor(ctx)
+		}
+	}
+
+	if !*dryRun {
+		go retryDeliveries(leaderCtx, messengers)
+	}
+	notificationsReceiver(leaderCtx, messengers)
+
+	if errors.Is(context.Cause(leaderCtx), errLeadershipLost) {
+		// messenger connections are not meant to be restarted, let the supervisor start a fresh standby
+		log.Fatal().Msg("leadership lost, terminating")
+	}
 
 	log.Info().Msg("onlineconf-bot stopped")
 }
@@ -0,0 +1,63 @@
+package onlineconfbot
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/colinmarc/cdb"
+	"github.com/onlineconf/onlineconf-go"
+)
+
+var testConfigDir string
+var testConfigs int
+
+// TestMain points config to an empty module, so every parameter takes its default value.
+func TestMain(m *testing.M) {
+	var err error
+	testConfigDir, err = os.MkdirTemp("", "onlineconf-bot-test")
+	if err != nil {
+		panic(err)
+	}
+
+	onlineconf.Initialize(testConfigDir)
+	config = newTestConfig(nil)
+
+	code := m.Run()
+	os.RemoveAll(testConfigDir)
+	os.Exit(code)
+}
+
+// withConfig replaces config with a module holding params for the duration of the test.
+// Values starting with "[" or "{" are JSON, the others are strings.
+func withConfig(t *testing.T, params map[string]string) {
+	t.Helper()
+	prev := config
+	config = newTestConfig(params)
+	t.Cleanup(func() { config = prev })
+}
+
+func newTestConfig(params map[string]string) *onlineconf.Module {
+	testConfigs++
+	name := "test-" + strconv.Itoa(testConfigs)
+
+	w, err := cdb.Create(filepath.Join(testConfigDir, name+".cdb"))
+	if err != nil {
+		panic(err)
+	}
+	for path, value := range params {
+		format := "s"
+		if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+			format = "j"
+		}
+		if err := w.Put([]byte(path), []byte(format+value)); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return onlineconf.GetModule(name)
+}
@@ -0,0 +1,65 @@
+package onlineconfbot
+
+import (
+	"html"
+	"strings"
+)
+
+// Markup describes how a messenger formats rich text.
+// Notification.Format uses it to escape user-controlled content such as values and comments.
+type Markup struct {
+	Escape    func(string) string                // escapes text so it is shown verbatim
+	CodeBlock func(code, language string) string // renders a multi-line value, language is "json", "yaml" or empty
+	Link      func(text, url string) string      // renders a link, text is escaped already; nil means Bot.ParamLink
+	NewLine   string                             // line separator, "\n" if empty
+}
+
+// Markdown is the markup of Notification.Text: nothing is escaped and values are fenced code blocks.
+var Markdown = &Markup{
+	Escape:    func(s string) string { return s },
+	CodeBlock: markdownCodeBlock,
+}
+
+// HTML is the markup for messengers accepting HTML messages.
+var HTML = &Markup{
+	Escape: html.EscapeString,
+	CodeBlock: func(code, _ string) string {
+		return "<pre>" + html.EscapeString(strings.TrimSuffix(code, "\n")) + "</pre>"
+	},
+	Link: func(text, url string) string {
+		return `<a href="` + html.EscapeString(url) + `">` + text + "</a>"
+	},
+}
+
+func (markup *Markup) newLine() string {
+	if markup.NewLine == "" {
+		return "\n"
+	}
+	return markup.NewLine
+}
+
+func markdownCodeBlock(code, language string) string {
+	block := strings.Builder{}
+	block.WriteString("```")
+	block.WriteString(language)
+	block.WriteString("\n")
+	block.WriteString(code)
+
+	if strings.HasSuffix(code, "\n") {
+		block.WriteString("```")
+	} else {
+		block.WriteString("\n```")
+	}
+	return block.String()
+}
+
+func codeLanguage(contentType string) string {
+	switch contentType {
+	case "application/json":
+		return "json"
+	case "application/x-yaml":
+		return "yaml"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,77 @@
+package onlineconfbot
+
+import (
+	"testing"
+)
+
+func TestMarkup(t *testing.T) {
+	tests := []struct {
+		name   string
+		markup *Markup
+		text   string
+		code   string
+		lang   string
+		url    string
+
+		wantEscape string
+		wantCode   string
+		wantLink   string
+	}{
+		{
+			name:       "markdown",
+			markup:     Markdown,
+			text:       "a <b> & *c*",
+			code:       "{\"a\": 1}",
+			lang:       "json",
+			wantEscape: "a <b> & *c*",
+			wantCode:   "```json\n{\"a\": 1}\n```",
+		},
+		{
+			name:     "markdown code with trailing newline",
+			markup:   Markdown,
+			code:     "key: value\n",
+			lang:     "yaml",
+			wantCode: "```yaml\nkey: value\n```",
+		},
+		{
+			name:       "html",
+			markup:     HTML,
+			text:       `a <b> & "c"`,
+			code:       "<tag>\n",
+			url:        `https://example.com/?a=1&b="2"`,
+			wantEscape: "a &lt;b&gt; &amp; &#34;c&#34;",
+			wantCode:   "<pre>&lt;tag&gt;</pre>",
+			wantLink:   `<a href="https://example.com/?a=1&amp;b=&#34;2&#34;">text</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.markup.Escape(tt.text); got != tt.wantEscape {
+				t.Errorf("Escape(%q) = %q, want %q", tt.text, got, tt.wantEscape)
+			}
+			if got := tt.markup.CodeBlock(tt.code, tt.lang); got != tt.wantCode {
+				t.Errorf("CodeBlock(%q, %q) = %q, want %q", tt.code, tt.lang, got, tt.wantCode)
+			}
+			if tt.markup.Link == nil {
+				return
+			}
+			if got := tt.markup.Link("text", tt.url); got != tt.wantLink {
+				t.Errorf("Link(%q) = %q, want %q", tt.url, got, tt.wantLink)
+			}
+		})
+	}
+}
+
+func TestCodeLanguage(t *testing.T) {
+	tests := map[string]string{
+		"application/json":   "json",
+		"application/x-yaml": "yaml",
+		"text/plain":         "",
+	}
+	for contentType, want := range tests {
+		if got := codeLanguage(contentType); got != want {
+			t.Errorf("codeLanguage(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package onlineconfbot
+
+import (
+	"reflect"
+
+	"github.com/onlineconf/onlineconf-go"
+)
+
+// BotConstructor creates the bot of a messenger, see MultiBotMain.
+type BotConstructor func(*onlineconf.Module, SubscriptionStorage) (Bot, error)
+
+// Constructor adapts a constructor returning a concrete bot type to BotConstructor.
+func Constructor[botType Bot](newBot func(*onlineconf.Module, SubscriptionStorage) (botType, error)) BotConstructor {
+	return func(config *onlineconf.Module, subscr SubscriptionStorage) (Bot, error) {
+		bot, err := newBot(config, subscr)
+		if err != nil {
+			return nil, err
+		}
+		return bot, nil
+	}
+}
+
+// messenger is a bot run by the process together with the storage of its subscriptions and deliveries.
+type messenger struct {
+	name string    // empty in a single-messenger process
+	bot  Bot       // the bot wrapped by dryRunBot in dry-run mode
+	raw  Bot       // the bot as created by its constructor
+	db   *database // scoped to the messenger
+}
+
+// messengers are all bots run by the process, in order of /messengers.
+var messengers []*messenger
+
+// messengerOf returns the messenger of a bot passed to helpers like ReplayCommand by the bot itself.
+// Bots are matched by pointer, so a bot of a value type is never found and gets the process-wide storage.
+func messengerOf(bot Bot) *messenger {
+	for _, m := range messengers {
+		if sameBot(m.raw, bot) || sameBot(m.bot, bot) {
+			return m
+		}
+	}
+	return &messenger{bot: bot, raw: bot, db: db}
+}
+
+// sameBot compares bots by pointer, comparing interfaces with == panics if their values are not comparable.
+func sameBot(a, b Bot) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Kind() == reflect.Pointer && vb.Kind() == reflect.Pointer &&
+		va.Type() == vb.Type() && va.Pointer() == vb.Pointer()
+}
+
+// configString reads a /user/... parameter preferring its messenger-specific variant, e.g. /myteam/user/domain,
+// so one process can map users differently for each messenger.
+func (m *messenger) configString(path, defaultValue string) string {
+	if m.name != "" {
+		if value, ok := config.GetStringIfExists("/" + m.name + path); ok {
+			return value
+		}
+	}
+	return config.GetString(path, defaultValue)
+}
+
+// configStrings is configString for list parameters.
+func (m *messenger) configStrings(path string, defaultValue []string) []string {
+	if m.name != "" {
+		if value := config.GetStrings("/"+m.name+path, nil); value != nil {
+			return value
+		}
+	}
+	return config.GetStrings(path, defaultValue)
+}
+
+// admins are messenger accounts allowed to run admin commands and receiving alerts,
+// /<messenger>/user/admins if set, /user/admins otherwise.
+func (m *messenger) admins() []string {
+	return m.configStrings("/user/admins", nil)
+}
+
+// configStruct is configString for JSON and YAML parameters.
+func (m *messenger) configStruct(path string, value any) {
+	if m.name != "" {
+		if ok, _ := config.GetStruct("/"+m.name+path, value); ok {
+			return
+		}
+	}
+	config.GetStruct(path, value)
+}
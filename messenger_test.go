@@ -0,0 +1,47 @@
+package onlineconfbot
+
+import (
+	"context"
+	"testing"
+)
+
+type nopBot struct{ name string } // not zero-sized, so messengerOf tells bots apart
+
+func (*nopBot) UpdatesProcessor(context.Context)               {}
+func (*nopBot) Notify(context.Context, string, *Message) error { return nil }
+func (*nopBot) MentionLink(user string) string                 { return user }
+func (*nopBot) ParamLink(param, link string) string            { return param }
+
+func TestAdmins(t *testing.T) {
+	myteamBot, slackBot, singleBot := &nopBot{"myteam"}, &nopBot{"slack"}, &nopBot{}
+	prev := messengers
+	messengers = []*messenger{
+		{name: "myteam", bot: myteamBot, raw: myteamBot},
+		{name: "slack", bot: slackBot, raw: slackBot},
+	}
+	t.Cleanup(func() { messengers = prev })
+
+	withConfig(t, map[string]string{
+		"/user/admins":       "alice, bob",
+		"/slack/user/admins": `["alice@example.com"]`,
+	})
+
+	tests := []struct {
+		bot  Bot
+		user string
+		want bool
+	}{
+		{bot: myteamBot, user: "alice", want: true},
+		{bot: myteamBot, user: "alice@example.com", want: false},
+		{bot: slackBot, user: "alice@example.com", want: true},
+		{bot: slackBot, user: "bob", want: false},
+		{bot: singleBot, user: "bob", want: true},
+		{bot: singleBot, user: "carol", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := IsAdmin(tt.bot, tt.user); got != tt.want {
+			t.Errorf("IsAdmin(%s, %q) = %v, want %v", messengerOf(tt.bot).name, tt.user, got, tt.want)
+		}
+	}
+}
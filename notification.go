@@ -6,6 +6,16 @@ import (
 	"strings"
 )
 
+const (
+	StyleEmoji   = "emoji"   // emoji badges and an avatar, the default
+	StylePlain   = "plain"   // words instead of emoji, friendly to screen readers
+	StyleCompact = "compact" // one line per change, combined into one message per batch
+
+	StyleCompactPlain = StyleCompact + "-" + StylePlain // StyleCompact with words instead of emoji
+)
+
+const defaultCompactValueLength = 40
+
 type Notification struct {
 	ID           int               `json:"id"`
 	Path         string            `json:"path"`
@@ -15,118 +25,276 @@ type Notification struct {
 	MTime        string            `json:"mtime"`
 	Author       string            `json:"author"`
 	mappedAuthor string            `json:"-"` // author's messenger account
+	profile      *AuthorProfile    `json:"-"` // author's messenger profile, if known
+	link         string            `json:"-"` // URL of the parameter in OnlineConf UI
+	pathLink     string            `json:"-"` // path rendered as a messenger link
 	Comment      string            `json:"comment"`
 	Action       string            `json:"action"`
 	Notification string            `json:"notification"`
 	Users        map[string]string `json:"users"`
 }
 
-func (notification *Notification) Text() string {
-	text := strings.Builder{}
-	text.WriteString(notification.MTime)
-	text.WriteString("\n")
-	text.WriteString(avatar(notification.Author))
-	text.WriteString(" ")
-	text.WriteString(notification.mappedAuthor)
-	text.WriteString("\n")
+type textStyle struct {
+	avatar      bool
+	actions     map[string]string
+	contentType func(string) string
+	branches    [5]string // server, group, datacenter, service, default
+	comment     string
+	symbolSpace string // between a content-type symbol and a case value
+}
+
+var textStyles = map[string]*textStyle{
+	StyleEmoji: {
+		avatar: true,
+		actions: map[string]string{
+			"delete": "❌️",
+			"create": "🆕️",
+			"modify": "✏️",
+		},
+		contentType: contentTypeSymbol,
+		branches:    [5]string{"ⓗ\u200a", "ⓖ\u200a", "ⓓ\u200a", "ⓢ\u200a", "☆️"},
+		comment:     "🗒 ",
+		symbolSpace: "\u200a",
+	},
+	StylePlain: {
+		actions: map[string]string{
+			"delete": "DELETED",
+			"create": "CREATED",
+			"modify": "MODIFIED",
+		},
+		contentType: contentTypeWord,
+		branches:    [5]string{"server: ", "group: ", "datacenter: ", "service: ", "default"},
+		comment:     "Comment: ",
+		symbolSpace: " ",
+	},
+}
+
+func validStyle(style string) bool {
+	_, ok := textStyles[style]
+	return ok || IsCompact(style)
+}
+
+// IsCompact reports whether the style renders one line per change, such messages combine notifications of a batch.
+func IsCompact(style string) bool {
+	return style == StyleCompact || style == StyleCompactPlain
+}
+
+// Text renders the notification in the given style using Markdown, unknown styles fall back to StyleEmoji.
+func (notification *Notification) Text(style string) string {
+	return notification.Format(style, Markdown)
+}
+
+// Format renders the notification in the given style using the messenger markup.
+func (notification *Notification) Format(style string, markup *Markup) string {
+	if IsCompact(style) {
+		return notification.compactText(style, markup)
+	}
+
+	ts, ok := textStyles[style]
+	if !ok {
+		ts = textStyles[StyleEmoji]
+	}
 
-	switch notification.Action {
-	case "delete":
-		text.WriteString("❌️")
-	case "create":
-		text.WriteString("🆕️")
-	case "modify":
-		text.WriteString("✏️")
+	nl := markup.newLine()
+	text := strings.Builder{}
+	text.WriteString(markup.Escape(notification.MTime))
+	text.WriteString(nl)
+	if ts.avatar {
+		text.WriteString(avatar(notification.Author))
+		text.WriteString(" ")
+	} else {
+		text.WriteString("Author: ")
 	}
+	text.WriteString(notification.authorText(markup))
+	text.WriteString(nl)
+
+	text.WriteString(ts.actions[notification.Action])
 	text.WriteString(" ")
-	text.WriteString(notification.Path)
+	text.WriteString(notification.pathText(markup))
 	if notification.Action != "delete" && notification.Notification == "with-value" {
-		if ct := contentTypeSymbol(notification.ContentType); ct != "" {
+		if ct := ts.contentType(notification.ContentType); ct != "" {
 			text.WriteString(" ")
 			text.WriteString(ct)
 		}
-		if notification.Value.Valid {
-			switch notification.ContentType {
-			case "application/x-case":
-				var data []map[string]string
-				err := json.Unmarshal([]byte(notification.Value.String), &data)
-				if err == nil {
-					for _, c := range data {
-						text.WriteString("\n")
-						if s, ok := c["server"]; ok {
-							text.WriteString("ⓗ ")
-							text.WriteString(s)
-						} else if g, ok := c["group"]; ok {
-							text.WriteString("ⓖ ")
-							text.WriteString(g)
-						} else if d, ok := c["datacenter"]; ok {
-							text.WriteString("ⓓ ")
-							text.WriteString(d)
-						} else if s, ok := c["service"]; ok {
-							text.WriteString("ⓢ ")
-							text.WriteString(s)
-						} else {
-							text.WriteString("☆️")
-						}
-						text.WriteString(": ")
-						ct := contentTypeSymbol(c["mime"])
-						value, ok := c["value"]
-						text.WriteString(ct)
-						if ok {
-							if ct != "" {
-								text.WriteString(" ")
-							}
-							if strings.ContainsRune(value, '"') {
-								text.WriteString("«")
-								text.WriteString(value)
-								text.WriteString("»")
-							} else {
-								text.WriteString("\"")
-								text.WriteString(value)
-								text.WriteString("\"")
-							}
-						}
-					}
+		notification.writeValue(&text, ts, markup)
+	}
+	if notification.Comment != "" {
+		text.WriteString(nl)
+		text.WriteString(ts.comment)
+		text.WriteString(markup.Escape(notification.Comment))
+	}
+	return text.String()
+}
+
+func (notification *Notification) writeValue(text *strings.Builder, ts *textStyle, markup *Markup) {
+	if !notification.Value.Valid {
+		return
+	}
+
+	nl := markup.newLine()
+	switch notification.ContentType {
+	case "application/x-case":
+		var data []map[string]string
+		err := json.Unmarshal([]byte(notification.Value.String), &data)
+		if err == nil {
+			for _, c := range data {
+				text.WriteString(nl)
+				if s, ok := c["server"]; ok {
+					text.WriteString(ts.branches[0])
+					text.WriteString(markup.Escape(s))
+				} else if g, ok := c["group"]; ok {
+					text.WriteString(ts.branches[1])
+					text.WriteString(markup.Escape(g))
+				} else if d, ok := c["datacenter"]; ok {
+					text.WriteString(ts.branches[2])
+					text.WriteString(markup.Escape(d))
+				} else if s, ok := c["service"]; ok {
+					text.WriteString(ts.branches[3])
+					text.WriteString(markup.Escape(s))
 				} else {
-					blockQuote(&text, notification.Value.String, "")
+					text.WriteString(ts.branches[4])
+				}
+				text.WriteString(": ")
+				ct := ts.contentType(c["mime"])
+				value, ok := c["value"]
+				text.WriteString(ct)
+				if ok {
+					if ct != "" {
+						text.WriteString(ts.symbolSpace)
+					}
+					if strings.ContainsRune(value, '"') {
+						text.WriteString("«")
+						text.WriteString(markup.Escape(value))
+						text.WriteString("»")
+					} else {
+						text.WriteString("\"")
+						text.WriteString(markup.Escape(value))
+						text.WriteString("\"")
+					}
 				}
-			case "application/x-symlink":
-				text.WriteString("\n")
-				text.WriteString(notification.Value.String)
-			default:
-				blockQuote(&text, notification.Value.String, notification.ContentType)
 			}
+		} else {
+			blockQuote(text, notification.Value.String, "", markup)
 		}
+	case "application/x-symlink":
+		text.WriteString(nl)
+		text.WriteString(markup.Escape(notification.Value.String))
+	default:
+		blockQuote(text, notification.Value.String, notification.ContentType, markup)
 	}
-	if notification.Comment != "" {
-		text.WriteString("\n🗒 ")
-		text.WriteString(notification.Comment)
+}
+
+// ValueText renders the value of the notification using Markdown without the header,
+// for bots which lay out notifications themselves.
+func (notification *Notification) ValueText(style string) string {
+	return notification.FormatValue(style, Markdown)
+}
+
+// FormatValue renders the value of the notification using the messenger markup without the header.
+func (notification *Notification) FormatValue(style string, markup *Markup) string {
+	ts, ok := textStyles[style]
+	if !ok {
+		ts = textStyles[StyleEmoji]
 	}
-	return text.String()
+
+	text := strings.Builder{}
+	if notification.Action != "delete" && notification.Notification == "with-value" {
+		notification.writeValue(&text, ts, markup)
+	}
+	return strings.TrimPrefix(text.String(), markup.newLine())
 }
 
-func blockQuote(text *strings.Builder, s, ctype string) {
-	text.WriteString("\n")
+// Redacted returns a copy of the notification safe to store or pass on: the value is removed unless
+// the parameter notifies with its value, as renderers never show it, and the access map of users is dropped.
+func (notification *Notification) Redacted() *Notification {
+	redacted := *notification
+	if notification.Notification != "with-value" {
+		redacted.Value = NullString{}
+	}
+	redacted.Users = nil
+	return &redacted
+}
 
-	if s != "" {
-		switch ctype {
-		case "application/json":
-			ctype = "json\n"
-		case "application/x-yaml":
-			ctype = "yaml\n"
-		default:
-			ctype = "\n"
+// AuthorMention is the author's messenger account rendered by Bot.MentionLink.
+func (notification *Notification) AuthorMention() string {
+	return notification.mappedAuthor
+}
+
+// AuthorProfile is the author's messenger profile, nil if unknown or lookups are disabled.
+func (notification *Notification) AuthorProfile() *AuthorProfile {
+	return notification.profile
+}
+
+// Link is the URL of the parameter in OnlineConf UI, empty if /onlineconf/link-url is not configured.
+func (notification *Notification) Link() string {
+	return notification.link
+}
+
+func (notification *Notification) pathText(markup *Markup) string {
+	if markup.Link == nil {
+		if notification.pathLink == "" {
+			return notification.Path
 		}
+		return notification.pathLink
+	}
 
-		text.WriteString("```")
-		text.WriteString(ctype)
-		text.WriteString(s)
+	if notification.link == "" {
+		return markup.Escape(notification.Path)
+	}
+	return markup.Link(markup.Escape(notification.Path), notification.link)
+}
 
-		if strings.HasSuffix(s, "\n") {
-			text.WriteString("```")
-		} else {
-			text.WriteString("\n```")
+// authorText is the author's mention preceded by the real name when the profile is known.
+func (notification *Notification) authorText(markup *Markup) string {
+	if notification.profile == nil || notification.profile.DisplayName == "" {
+		return notification.mappedAuthor
+	}
+	return markup.Escape(notification.profile.DisplayName+" (") + notification.mappedAuthor + markup.Escape(")")
+}
+
+// compactText renders the notification in one line using actions and content types of the base style.
+func (notification *Notification) compactText(style string, markup *Markup) string {
+	ts := textStyles[StyleEmoji]
+	if style == StyleCompactPlain {
+		ts = textStyles[StylePlain]
+	}
+
+	text := strings.Builder{}
+	text.WriteString(markup.Escape(notification.MTime))
+	text.WriteString(" ")
+	text.WriteString(notification.authorText(markup))
+	text.WriteString(" ")
+	text.WriteString(ts.actions[notification.Action])
+	text.WriteString(" ")
+	text.WriteString(notification.pathText(markup))
+	if notification.Action != "delete" && notification.Notification == "with-value" {
+		if ct := ts.contentType(notification.ContentType); ct != "" {
+			text.WriteString(" ")
+			text.WriteString(ct)
 		}
+		if notification.Value.Valid && notification.ContentType != "application/x-case" {
+			text.WriteString(markup.Escape(" = " + truncate(notification.Value.String, config.GetInt("/notification/compact-value-length", defaultCompactValueLength))))
+		}
+	}
+	return text.String()
+}
+
+var lineBreakRepl = strings.NewReplacer("\r\n", " ⏎ ", "\n", " ⏎ ", "\r", " ⏎ ")
+
+// truncate squashes s into a single line of at most max characters.
+func truncate(s string, max int) string {
+	r := []rune(lineBreakRepl.Replace(s))
+	if max > 0 && len(r) > max {
+		return string(r[:max]) + "…"
+	}
+	return string(r)
+}
+
+func blockQuote(text *strings.Builder, s, ctype string, markup *Markup) {
+	text.WriteString(markup.newLine())
+
+	if s != "" {
+		text.WriteString(markup.CodeBlock(s, codeLanguage(ctype)))
 	}
 }
 
@@ -155,3 +323,22 @@ func contentTypeSymbol(contentType string) string {
 		return ""
 	}
 }
+
+func contentTypeWord(contentType string) string {
+	switch contentType {
+	case "application/x-null":
+		return "NULL"
+	case "application/x-symlink":
+		return "SYMLINK"
+	case "application/x-case":
+		return "CASE"
+	case "application/x-template":
+		return "TEMPLATE"
+	case "application/json":
+		return "JSON"
+	case "application/x-yaml":
+		return "YAML"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,190 @@
+package onlineconfbot
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{s: "short", max: 10, want: "short"},
+		{s: "exactly10!", max: 10, want: "exactly10!"},
+		{s: "longer than ten", max: 10, want: "longer tha…"},
+		{s: "unlimited value", max: 0, want: "unlimited value"},
+		{s: "строка юникода", max: 6, want: "строка…"},
+		{s: "a\nb\r\nc\rd", max: 0, want: "a ⏎ b ⏎ c ⏎ d"},
+		{s: "a\nb", max: 3, want: "a ⏎…"},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.max); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestCompactText(t *testing.T) {
+	value := func(s string) NullString { return NullString{sql.NullString{String: s, Valid: true}} }
+	notification := func(action, contentType string, v NullString, mode string) *Notification {
+		return &Notification{
+			Path:         "/app/<db>",
+			ContentType:  contentType,
+			Value:        v,
+			MTime:        "2024-01-05 12:00:00",
+			mappedAuthor: "@alice",
+			Action:       action,
+			Notification: mode,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		notification *Notification
+		style        string
+		markup       *Markup
+		want         string
+	}{
+		{
+			name:         "emoji with value",
+			notification: notification("modify", "application/json", value(`{"a":1}`), "with-value"),
+			style:        StyleCompact,
+			markup:       Markdown,
+			want:         `2024-01-05 12:00:00 @alice ✏️ /app/<db> 🄹 = {"a":1}`,
+		},
+		{
+			name:         "plain with value",
+			notification: notification("modify", "application/json", value(`{"a":1}`), "with-value"),
+			style:        StyleCompactPlain,
+			markup:       Markdown,
+			want:         `2024-01-05 12:00:00 @alice MODIFIED /app/<db> JSON = {"a":1}`,
+		},
+		{
+			name:         "value hidden",
+			notification: notification("create", "text/plain", value("secret"), "no-value"),
+			style:        StyleCompactPlain,
+			markup:       Markdown,
+			want:         "2024-01-05 12:00:00 @alice CREATED /app/<db>",
+		},
+		{
+			name:         "deleted",
+			notification: notification("delete", "text/plain", value("old"), "with-value"),
+			style:        StyleCompact,
+			markup:       Markdown,
+			want:         "2024-01-05 12:00:00 @alice ❌️ /app/<db>",
+		},
+		{
+			name:         "case without value",
+			notification: notification("modify", "application/x-case", value(`[{"value":"x"}]`), "with-value"),
+			style:        StyleCompactPlain,
+			markup:       Markdown,
+			want:         "2024-01-05 12:00:00 @alice MODIFIED /app/<db> CASE",
+		},
+		{
+			name:         "null",
+			notification: notification("modify", "application/x-null", NullString{}, "with-value"),
+			style:        StyleCompact,
+			markup:       Markdown,
+			want:         "2024-01-05 12:00:00 @alice ✏️ /app/<db> ∅",
+		},
+		{
+			name:         "multi-line value truncated",
+			notification: notification("modify", "text/plain", value("line\n"+strings.Repeat("x", 50)), "with-value"),
+			style:        StyleCompactPlain,
+			markup:       Markdown,
+			want:         "2024-01-05 12:00:00 @alice MODIFIED /app/<db> = line ⏎ " + strings.Repeat("x", 33) + "…",
+		},
+		{
+			name:         "escaped",
+			notification: notification("modify", "text/plain", value("a < b"), "with-value"),
+			style:        StyleCompactPlain,
+			markup:       HTML,
+			want:         "2024-01-05 12:00:00 @alice MODIFIED /app/&lt;db&gt; = a &lt; b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.notification.Format(tt.style, tt.markup); got != tt.want {
+				t.Errorf("Format(%q) = %q, want %q", tt.style, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	value := func(s string) NullString { return NullString{sql.NullString{String: s, Valid: true}} }
+	notification := func(action, contentType string, v NullString, comment string) *Notification {
+		return &Notification{
+			Path:         "/app/db",
+			ContentType:  contentType,
+			Value:        v,
+			MTime:        "2024-01-05 12:00:00",
+			Author:       "alice",
+			mappedAuthor: "@alice",
+			Comment:      comment,
+			Action:       action,
+			Notification: "with-value",
+		}
+	}
+
+	tests := []struct {
+		name         string
+		notification *Notification
+		style        string
+		want         string
+	}{
+		{
+			name:         "json",
+			notification: notification("modify", "application/json", value(`{"a":1}`), "moved"),
+			style:        StylePlain,
+			want:         "2024-01-05 12:00:00\nAuthor: @alice\nMODIFIED /app/db JSON\n```json\n{\"a\":1}\n```\nComment: moved",
+		},
+		{
+			name:         "case",
+			notification: notification("create", "application/x-case", value(`[{"server":"db1","mime":"text/plain","value":"x"},{"mime":"application/x-null"}]`), ""),
+			style:        StylePlain,
+			want:         "2024-01-05 12:00:00\nAuthor: @alice\nCREATED /app/db CASE\nserver: db1: \"x\"\ndefault: NULL",
+		},
+		{
+			name:         "deleted",
+			notification: notification("delete", "text/plain", value("old"), ""),
+			style:        StylePlain,
+			want:         "2024-01-05 12:00:00\nAuthor: @alice\nDELETED /app/db",
+		},
+		{
+			name: "profile",
+			notification: func() *Notification {
+				n := notification("delete", "text/plain", value("old"), "")
+				n.profile = &AuthorProfile{DisplayName: "Alice Smith"}
+				return n
+			}(),
+			style: StyleCompactPlain,
+			want:  "2024-01-05 12:00:00 Alice Smith (@alice) DELETED /app/db",
+		},
+		{
+			name:         "symlink",
+			notification: notification("modify", "application/x-symlink", value("/app/other"), ""),
+			style:        StyleCompactPlain,
+			want:         "2024-01-05 12:00:00 @alice MODIFIED /app/db SYMLINK = /app/other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.notification.Format(tt.style, Markdown)
+			if got != tt.want {
+				t.Errorf("Format(%q) = %q, want %q", tt.style, got, tt.want)
+			}
+			for _, emoji := range []string{"✏️", "🆕️", "❌️", "🄹", "⌥", "➦", "☆️", avatar("alice")} {
+				if strings.Contains(got, emoji) {
+					t.Errorf("Format(%q) = %q contains %s", tt.style, got, emoji)
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -15,12 +16,18 @@ import (
 
 var ErrStatusNotOK = errors.New("response status code is not 200")
 
+var ErrUnauthorized = errors.New("BotAPI rejected credentials")
+
+// dryRunLastID is the cursor kept in memory in dry-run mode, the lastid table is never updated.
+var dryRunLastID int
+
 type NotificationsResponse struct {
 	Notifications []Notification `json:"notifications"`
 	LastID        int            `json:"lastID"`
 }
 
-func getNotifications(ctx context.Context, lastID, limit int) (*NotificationsResponse, error) {
+// getNotifications requests notifications following lastID, waiting up to wait seconds for new ones.
+func getNotifications(ctx context.Context, lastID, limit, wait int) (*NotificationsResponse, error) {
 	uri, err := url.ParseRequestURI(config.GetString("/onlineconf/botapi/url", ""))
 	if err != nil {
 		return nil, err
@@ -29,25 +36,32 @@ func getNotifications(ctx context.Context, lastID, limit int) (*NotificationsRes
 	uri.RawQuery = url.Values{
 		"lastID": []string{strconv.Itoa(lastID)},
 		"limit":  []string{strconv.Itoa(limit)},
-		"wait":   []string{config.GetString("/onlineconf/botapi/wait", "60")},
+		"wait":   []string{strconv.Itoa(wait)},
 	}.Encode()
-	req, err := http.NewRequestWithContext(ctx, "GET", uri.String(), nil)
+	client, err := getBotAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, botAPITimeout(wait))
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, "GET", uri.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	req.SetBasicAuth(
-		config.GetString("/onlineconf/botapi/username", commandName),
-		config.GetString("/onlineconf/botapi/password", ""),
-	)
-	resp, err := http.DefaultClient.Do(req)
+	setBotAPIAuth(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		if !errors.Is(err, context.Canceled) {
 			log.Ctx(ctx).Error().Str("method", req.Method).Str("url", req.URL.String()).Err(err).Msg("failed to get notifications")
 		}
 		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		log.Ctx(ctx).Error().Str("method", req.Method).Str("url", req.URL.String()).Int("status", resp.StatusCode).Msg("failed to get notifications")
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: status %d", ErrUnauthorized, resp.StatusCode)
+		}
 		return nil, ErrStatusNotOK
 	}
 	var response NotificationsResponse
@@ -58,7 +72,7 @@ func getNotifications(ctx context.Context, lastID, limit int) (*NotificationsRes
 	return &response, nil
 }
 
-func processNotifications(ctx context.Context, bot Bot, limit int) (next bool, err error) {
+func processNotifications(ctx context.Context, ms []*messenger, limit int) (next bool, err error) {
 	waitCtx, cancel := context.WithCancel(log.Ctx(ctx).WithContext(context.Background()))
 	defer cancel()
 	go func() {
@@ -76,32 +90,49 @@ func processNotifications(ctx context.Context, bot Bot, limit int) (next bool, e
 		}
 	}()
 
-	tx, err := db.BeginTx(waitCtx)
+	// no transaction is held while waiting for and sending notifications,
+	// the cursor is advanced with a compare-and-set afterwards
+	lastID, err := db.GetLastID(ctx)
 	if err != nil {
 		return false, err
 	}
-	lastID, err := tx.GetLastID(ctx)
-	if err != nil {
-		return false, err
+	if *dryRun && dryRunLastID != 0 {
+		lastID = dryRunLastID
 	}
-	log.Ctx(ctx).Debug().Int("lastID", lastID).Msg("got lastID")
-	defer tx.Commit()
-
 	if lastID == 0 {
-		limit = 0
+		// the normal long poll follows, even if BotAPI has no notifications yet
+		if lastID, err = backfill(ctx, ms); err != nil {
+			return false, err
+		}
 	}
-	notifications, err := getNotifications(ctx, lastID, limit)
+	log.Ctx(ctx).Debug().Int("lastID", lastID).Msg("got lastID")
+
+	notifications, err := getNotifications(ctx, lastID, limit, config.GetInt("/onlineconf/botapi/wait", 60))
 	if err != nil {
 		return false, err
 	}
 	if notifications.LastID == lastID && len(notifications.Notifications) == 0 {
 		return false, nil
 	}
+	if anomalies := checkNotifications(lastID, config.GetInt("/onlineconf/anomaly/max-gap", defaultMaxIDGap), notifications); len(anomalies) > 0 {
+		for _, anomaly := range anomalies {
+			log.Ctx(ctx).Warn().Int("lastID", lastID).Int("botapiLastID", notifications.LastID).Msg(anomaly)
+		}
+		alertAdmins(waitCtx, "⚠️ OnlineConf BotAPI anomaly:\n"+strings.Join(anomalies, "\n"))
+	}
+	if notifications.LastID < lastID && !*dryRun {
+		// the cursor follows BotAPI, so the sent-log must not suppress reused IDs
+		if _, err := db.ForgetSentAfter(ctx, notifications.LastID); err != nil {
+			return false, err
+		}
+	}
 
 	newLastID := 0
 	defer func() {
-		if newLastID != 0 {
-			if setErr := tx.SetLastID(waitCtx, newLastID); setErr == nil {
+		if newLastID != 0 && *dryRun {
+			dryRunLastID = newLastID
+		} else if newLastID != 0 && newLastID != lastID {
+			if setErr := db.AdvanceLastID(waitCtx, lastID, newLastID); setErr == nil {
 				log.Ctx(ctx).Debug().Int("lastID", newLastID).Msg("new lastID")
 			} else if err == nil || errors.Is(err, context.Canceled) {
 				err = setErr
@@ -109,8 +140,10 @@ func processNotifications(ctx context.Context, bot Bot, limit int) (next bool, e
 		}
 	}()
 
-	notifier := newNotifier(bot)
-	for _, notification := range notifications.Notifications {
+	notifier := newNotifiers(ms)
+	defer notifier.flush(waitCtx)
+	for i := range notifications.Notifications {
+		notification := &notifications.Notifications[i]
 		err := notifier.notify(waitCtx, notification)
 		if err != nil {
 			return false, err
@@ -126,19 +159,30 @@ func processNotifications(ctx context.Context, bot Bot, limit int) (next bool, e
 	return len(notifications.Notifications) > 0, nil
 }
 
-func notificationsReceiver(ctx context.Context, bot Bot) {
+func notificationsReceiver(ctx context.Context, ms []*messenger) {
+	delay := time.Duration(config.GetInt("/onlineconf/interval", 1)) * time.Second
 	for {
-		timer := time.NewTimer(time.Duration(config.GetInt("/onlineconf/interval", 1)) * time.Second)
+		timer := time.NewTimer(delay)
 		select {
 		case <-ctx.Done():
 			timer.Stop()
 			return
 		case <-timer.C:
+			interval := time.Duration(config.GetInt("/onlineconf/interval", 1)) * time.Second
+			delay = interval
+			pollerBreaker.attempt()
 			for processNow := true; processNow; {
 				var err error
-				processNow, err = processNotifications(ctx, bot, config.GetInt("/onlineconf/batch-size", 100))
-				if err != nil && !errors.Is(err, context.Canceled) {
-					log.Ctx(ctx).Error().Err(err).Msg("failed to process notifications")
+				processNow, err = processNotifications(ctx, ms, config.GetInt("/onlineconf/batch-size", 100))
+				if err == nil {
+					pollerBreaker.success()
+				} else if !errors.Is(err, context.Canceled) {
+					var alert bool
+					delay, alert = pollerBreaker.failure(err, interval)
+					log.Ctx(ctx).Error().Err(err).Dur("retry_in", delay).Msg("failed to process notifications")
+					if alert {
+						alertAdmins(ctx, "⚠️ OnlineConf BotAPI rejects credentials of the bot, notifications are not delivered: "+err.Error())
+					}
 				}
 			}
 		}
@@ -146,21 +190,91 @@ func notificationsReceiver(ctx context.Context, bot Bot) {
 }
 
 type Notifier struct {
-	bot     Bot
-	userMap map[string]string
-	domain  string
+	bot       Bot
+	messenger string
+	db        *database
+	userMap   map[string]string
+	domain    string
+	style     string
+	userStyle map[string]string
+	origins   map[string]string   // OnlineConf usernames of recipients
+	digests   map[string]*Message // pending compact notifications by recipient
+	digestFor []string            // recipients in order of their first pending notification
+	pool      *deliveryPool
+	replay    bool     // notifications are sent again on purpose, the sent-log is not checked
+	onlyUser  string   // send only to this messenger account if it can view the parameter
+	fixedTo   []string // send to these messenger accounts regardless of access and subscriptions
+	label     string   // shown before the notifications
+	audience  []string // fixed recipients of a Broadcaster bot, nil for other bots
 }
 
-func newNotifier(bot Bot) Notifier {
-	ret := Notifier{
-		bot:    bot,
-		domain: config.GetString("/user/domain", ""),
+func newNotifier(m *messenger) *Notifier {
+	ret := &Notifier{
+		bot:       m.bot,
+		messenger: m.name,
+		db:        m.db,
+		domain:    m.configString("/user/domain", ""),
+		style:     config.GetString("/notification/style", StyleEmoji),
+		origins:   make(map[string]string),
+		digests:   make(map[string]*Message),
 	}
 
-	config.GetStruct("/user/map", &ret.userMap)
+	if broadcaster, ok := m.raw.(Broadcaster); ok {
+		ret.audience = broadcaster.Recipients()
+	}
+	m.configStruct("/user/map", &ret.userMap)
+	m.configStruct("/user/style", &ret.userStyle)
+	return ret
+}
+
+// notifiers deliver the same notifications through every messenger of the process.
+type notifiers []*Notifier
+
+func newNotifiers(ms []*messenger) notifiers {
+	ret := make(notifiers, len(ms))
+	for i, m := range ms {
+		ret[i] = newNotifier(m)
+	}
 	return ret
 }
 
+// notify delivers the notification through every messenger even if some of them fail, the errors are joined.
+func (ntfs notifiers) notify(ctx context.Context, notification *Notification) error {
+	var errs []error
+	for _, ntf := range ntfs {
+		// prepare renders messenger-specific parts into the notification, so each messenger needs its own copy
+		copied := *notification
+		if err := ntf.notify(ctx, &copied); err != nil {
+			if ntf.messenger != "" {
+				err = fmt.Errorf("%s: %w", ntf.messenger, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ntfs notifiers) flush(ctx context.Context) {
+	for _, ntf := range ntfs {
+		ntf.flush(ctx)
+	}
+}
+
+// styleFor returns the rendering style preferred by the messenger account.
+func (ntf *Notifier) styleFor(ctx context.Context, user string) string {
+	style, ok := ntf.userStyle[user]
+	if !ok {
+		style = ntf.style
+	}
+
+	if !validStyle(style) {
+		log.Ctx(ctx).Warn().Str("user", user).Str("style", style).Msg("unknown notification style, using default")
+		return StyleEmoji
+	}
+
+	return style
+}
+
 func (ntf *Notifier) mapUser(origUser string) string {
 	user, ok := ntf.userMap[origUser]
 	if !ok {
@@ -174,43 +288,112 @@ func (ntf *Notifier) mapUser(origUser string) string {
 	return user
 }
 
-func (ntf *Notifier) notify(ctx context.Context, notification Notification) error {
+// prepare renders messenger-specific parts of the notification: author mention, profile and links.
+func (ntf *Notifier) prepare(ctx context.Context, notification *Notification) {
+	author := ntf.mapUser(notification.Author)
+	notification.mappedAuthor = ntf.bot.MentionLink(author)
+	if provider, ok := ntf.bot.(ProfileProvider); ok && config.GetBool("/user/profile/enabled", false) {
+		notification.profile = lookupProfile(ctx, provider, ntf.messenger, author)
+	}
+
+	notification.link = ""
+	if linkURLstr, hasLinkURL := config.GetStringIfExists("/onlineconf/link-url"); hasLinkURL {
+		if linkURL, err := url.ParseRequestURI(linkURLstr); err == nil {
+			linkURL.Fragment = notification.Path
+			notification.link = linkURL.String()
+		} else {
+			log.Ctx(ctx).Warn().Err(err).Msg("failed to parse link URL")
+		}
+	}
+
+	notification.pathLink = ntf.bot.ParamLink(notification.Path, notification.link)
+}
+
+func (ntf *Notifier) notify(ctx context.Context, notification *Notification) error {
 	users := make(map[string]string, len(notification.Users))
 
 	for user, access := range notification.Users {
-		users[ntf.mapUser(user)] = access
+		mapped := ntf.mapUser(user)
+		users[mapped] = access
+		ntf.origins[mapped] = user
 	}
 
-	if len(users) == 0 {
+	if len(users) == 0 && ntf.audience == nil {
 		return nil
 	}
 
-	notification.mappedAuthor = ntf.bot.MentionLink(ntf.mapUser(notification.Author))
+	ntf.prepare(ctx, notification)
 
-	notifyUsers, err := db.FilterSubscribed(ctx, users)
-	if err != nil {
-		return err
+	var notifyUsers []string
+	if ntf.fixedTo != nil {
+		notifyUsers = ntf.fixedTo
+	} else if ntf.onlyUser != "" {
+		if access := users[ntf.onlyUser]; access == "rw" || access == "ro" {
+			notifyUsers = []string{ntf.onlyUser}
+		}
+	} else if ntf.audience != nil {
+		notifyUsers = ntf.audience
+	} else {
+		var err error
+		notifyUsers, err = ntf.db.FilterSubscribed(ctx, users)
+		if err != nil {
+			return err
+		}
 	}
 
-	link := ""
-	if linkURLstr, hasLinkURL := config.GetStringIfExists("/onlineconf/link-url"); hasLinkURL {
-		if linkURL, err := url.ParseRequestURI(linkURLstr); err == nil {
-			linkURL.Fragment = notification.Path
-			link = linkURL.String()
-		} else {
-			log.Ctx(ctx).Warn().Err(err).Msg("failed to parse link URL")
+	if !ntf.replay {
+		var err error
+		notifyUsers, err = ntf.unsent(ctx, notification, notifyUsers)
+		if err != nil {
+			return err
 		}
 	}
 
-	notification.Path = ntf.bot.ParamLink(notification.Path, link)
-
-	text := notification.Text()
+	messages := make(map[string]*Message)
 
 	for _, user := range notifyUsers {
-		if err = ntf.bot.Notify(ctx, user, link, text); err != nil {
-			log.Ctx(ctx).Error().Err(err).Msg("failed to send notification")
+		style := ntf.styleFor(ctx, user)
+
+		if IsCompact(style) {
+			ntf.addToDigest(user, style, notification)
+			continue
 		}
+
+		msg, ok := messages[style]
+		if !ok {
+			msg = newMessage(style, ntf.label, []*Notification{notification})
+			messages[style] = msg
+		}
+
+		ntf.deliver(ctx, user, msg)
 	}
 
 	return nil
 }
+
+func (ntf *Notifier) addToDigest(user, style string, notification *Notification) {
+	digest, ok := ntf.digests[user]
+	if !ok {
+		digest = &Message{Link: notification.link, Style: style, Label: ntf.label}
+		ntf.digests[user] = digest
+		ntf.digestFor = append(ntf.digestFor, user)
+	} else if digest.Link != notification.link {
+		digest.Link = ""
+	}
+
+	digest.Notifications = append(digest.Notifications, notification)
+}
+
+// flush sends compact notifications collected during the batch, one message per recipient,
+// and waits until all messages of the batch are sent.
+func (ntf *Notifier) flush(ctx context.Context) {
+	for _, user := range ntf.digestFor {
+		digest := ntf.digests[user]
+		digest.Text = digest.Format(Markdown)
+		ntf.deliver(ctx, user, digest)
+	}
+	ntf.wait()
+
+	ntf.digests = make(map[string]*Message)
+	ntf.digestFor = nil
+}
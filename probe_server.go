@@ -12,6 +12,7 @@ import (
 const (
 	defaultProbeServerAddr      = "0.0.0.0:8000"
 	defaultProbeServerUri       = "/probe"
+	defaultProbeServerStatusUri = "/status"
 	defaultProbeServerEnabled   = false
 	defaultProbeServerLogPrefix = ""
 )
@@ -41,7 +42,7 @@ func (ps *probeServer) Run(ctx context.Context) error {
 	return ps.server.ListenAndServe()
 }
 
-func newProbeServer(addr string, uri string, enabled bool) ProbeServer {
+func newProbeServer(addr string, uri string, statusURI string, enabled bool) ProbeServer {
 	if !enabled {
 		log.Info().Msg("Probe-Server starting is not enabled")
 		return &probeServer{}
@@ -57,10 +58,16 @@ func newProbeServer(addr string, uri string, enabled bool) ProbeServer {
 		log.Debug().Str("probe-uri", uri).Msg("Probe uri is not set. Using default")
 	}
 
+	if statusURI == "" {
+		statusURI = defaultProbeServerStatusUri
+		log.Debug().Str("probe-status-uri", statusURI).Msg("Probe status uri is not set. Using default")
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc(uri, func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
+	mux.Handle(statusURI, pollerBreaker)
 
 	return &probeServer{
 		server: &http.Server{
@@ -77,6 +84,7 @@ func ProbeServerIfEnabled() ProbeServer {
 	return newProbeServer(
 		config.GetString("/probe/addr", ""),
 		config.GetString("/probe/uri", ""),
+		config.GetString("/probe/status-uri", ""),
 		config.GetBool("/probe/enabled", defaultProbeServerEnabled),
 	)
 }
@@ -0,0 +1,74 @@
+package onlineconfbot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultProfileCacheTTL         = 3600 // seconds
+	defaultProfileNegativeCacheTTL = 60   // seconds failed lookups and unknown users are cached for
+)
+
+// AuthorProfile is a messenger profile of a notification author.
+type AuthorProfile struct {
+	DisplayName string // real name of the person
+	IconURL     string // URL of the profile image, if the messenger provides one
+}
+
+// ProfileProvider is implemented by bots able to look up profiles of their users.
+// A nil profile without an error means the user is unknown.
+type ProfileProvider interface {
+	AuthorProfile(ctx context.Context, user string) (*AuthorProfile, error)
+}
+
+type cachedProfile struct {
+	profile *AuthorProfile
+	expires time.Time
+}
+
+var profileCache = struct {
+	sync.Mutex
+	profiles map[profileKey]cachedProfile
+}{profiles: make(map[profileKey]cachedProfile)}
+
+type profileKey struct {
+	messenger string
+	user      string
+}
+
+// lookupProfile returns a cached profile of the messenger account or asks the bot for it.
+// Failed lookups and unknown users are cached briefly, so a broken messenger API is not hammered on every notification
+// but a transient error does not hide the profile for long.
+func lookupProfile(ctx context.Context, provider ProfileProvider, messenger, user string) *AuthorProfile {
+	key := profileKey{messenger, user}
+	profileCache.Lock()
+	cached, ok := profileCache.profiles[key]
+	profileCache.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.profile
+	}
+
+	profile, err := provider.AuthorProfile(ctx, user)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("user", user).Msg("failed to get author profile")
+	}
+
+	ttl := config.GetInt("/user/profile/cache-ttl", defaultProfileCacheTTL)
+	if err != nil || profile == nil {
+		ttl = min(ttl, config.GetInt("/user/profile/negative-cache-ttl", defaultProfileNegativeCacheTTL))
+	}
+
+	profileCache.Lock()
+	profileCache.profiles[key] = cachedProfile{
+		profile: profile,
+		expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	profileCache.Unlock()
+
+	return profile
+}
@@ -0,0 +1,153 @@
+package onlineconfbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/onlineconf/onlineconf-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRateLimit       = 10 // requests per second
+	defaultRateLimitBurst  = 10
+	defaultRetryAfter      = time.Second
+	maxRateLimitedAttempts = 5
+)
+
+// RateLimitedError is returned by bots when the messenger API rejects a request as exceeding its rate limit.
+type RateLimitedError struct {
+	RetryAfter time.Duration // zero if the API has not said how long to wait
+	Err        error
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.Err == nil {
+		return "rate limit exceeded"
+	}
+	return "rate limit exceeded: " + e.Err.Error()
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return e.Err
+}
+
+// ParseRetryAfter parses the Retry-After header value given either in seconds or as an HTTP date.
+// It returns zero if the value is missing, invalid or already passed.
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// RateLimiter is a token bucket pacing requests to a messenger API.
+type RateLimiter struct {
+	mu          sync.Mutex
+	rate        float64 // tokens per second, no limit if not positive
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// RateLimiterFromConfig creates a rate limiter configured by the rate-limit parameters of the messenger section,
+// e.g. /mattermost/rate-limit/rps and /mattermost/rate-limit/burst.
+func RateLimiterFromConfig(config *onlineconf.Module, section string) *RateLimiter {
+	rate := float64(defaultRateLimit)
+	if s, ok := config.GetStringIfExists(section + "/rate-limit/rps"); ok {
+		if r, err := strconv.ParseFloat(s, 64); err == nil {
+			rate = r
+		} else {
+			log.Warn().Err(err).Str("section", section).Msg("invalid rate limit, using default")
+		}
+	}
+	return NewRateLimiter(rate, config.GetInt(section+"/rate-limit/burst", defaultRateLimitBurst))
+}
+
+// Wait blocks until a request is allowed.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		var wait time.Duration
+		if now.Before(l.pausedUntil) {
+			wait = l.pausedUntil.Sub(now)
+		} else if l.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		} else {
+			l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+			l.last = now
+			if l.tokens >= 1 {
+				l.tokens--
+				l.mu.Unlock()
+				return nil
+			}
+			wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pause stops all requests for the given duration, used when the API reports its rate limit is exceeded.
+func (l *RateLimiter) Pause(d time.Duration) {
+	if d <= 0 {
+		d = defaultRetryAfter
+	}
+
+	l.mu.Lock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	l.mu.Unlock()
+}
+
+// Do calls fn when allowed by the limiter and calls it again after a pause if it returns RateLimitedError.
+func (l *RateLimiter) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := l.Wait(ctx); err != nil {
+			return err
+		}
+
+		err := fn()
+
+		var limited *RateLimitedError
+		if !errors.As(err, &limited) || attempt >= maxRateLimitedAttempts {
+			return err
+		}
+
+		log.Ctx(ctx).Warn().Dur("retry_after", limited.RetryAfter).Int("attempt", attempt).Msg("messenger API rate limit exceeded")
+		l.Pause(limited.RetryAfter)
+	}
+}
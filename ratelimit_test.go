@@ -0,0 +1,154 @@
+package onlineconfbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{name: "empty", value: ""},
+		{name: "seconds", value: "5", min: 5 * time.Second, max: 5 * time.Second},
+		{name: "zero seconds", value: "0"},
+		{name: "negative seconds", value: "-3"},
+		{name: "invalid", value: "soon"},
+		{name: "http date", value: time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat), min: 28 * time.Second, max: 30 * time.Second},
+		{name: "past http date", value: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseRetryAfter(tt.value); got < tt.min || got > tt.max {
+				t.Errorf("ParseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+// elapsed returns how long fn takes.
+func elapsed(fn func()) time.Duration {
+	start := time.Now()
+	fn()
+	return time.Since(start)
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewRateLimiter(20, 3) // a token per 50ms
+
+	if d := elapsed(func() {
+		for i := 0; i < 3; i++ {
+			if err := limiter.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}); d > 20*time.Millisecond {
+		t.Errorf("burst of 3 requests took %v, want no wait", d)
+	}
+
+	if d := elapsed(func() {
+		if err := limiter.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}); d < 30*time.Millisecond {
+		t.Errorf("request after the burst took %v, want a wait for a refilled token", d)
+	}
+
+	time.Sleep(120 * time.Millisecond) // refills two tokens
+	if d := elapsed(func() {
+		for i := 0; i < 2; i++ {
+			if err := limiter.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}); d > 20*time.Millisecond {
+		t.Errorf("2 requests after the refill took %v, want no wait", d)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := NewRateLimiter(0, 1)
+	if d := elapsed(func() {
+		for i := 0; i < 100; i++ {
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}); d > 20*time.Millisecond {
+		t.Errorf("unlimited requests took %v, want no wait", d)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	limiter.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRateLimiterDo(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name      string
+		results   []error // returned by consecutive calls, the last one is repeated
+		wantCalls int
+		wantErr   bool
+		minWait   time.Duration
+	}{
+		{name: "success", results: []error{nil}, wantCalls: 1},
+		{name: "other error is not retried", results: []error{errFailed}, wantCalls: 1, wantErr: true},
+		{
+			name:      "retried after retry-after",
+			results:   []error{&RateLimitedError{RetryAfter: 50 * time.Millisecond}, nil},
+			wantCalls: 2,
+			minWait:   40 * time.Millisecond,
+		},
+		{
+			name:      "wrapped rate limit error",
+			results:   []error{&RateLimitedError{RetryAfter: time.Millisecond, Err: errFailed}, &RateLimitedError{RetryAfter: time.Millisecond}, nil},
+			wantCalls: 3,
+		},
+		{
+			name:      "gives up",
+			results:   []error{&RateLimitedError{RetryAfter: time.Millisecond}},
+			wantCalls: maxRateLimitedAttempts,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(0, 1)
+			calls := 0
+			var err error
+			d := elapsed(func() {
+				err = limiter.Do(context.Background(), func() error {
+					result := tt.results[min(calls, len(tt.results)-1)]
+					calls++
+					return result
+				})
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("Do() called fn %d times, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Do() = %v, want error %v", err, tt.wantErr)
+			}
+			if d < tt.minWait {
+				t.Errorf("Do() took %v, want at least %v", d, tt.minWait)
+			}
+		})
+	}
+}
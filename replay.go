@@ -0,0 +1,119 @@
+package onlineconfbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayUsage describes arguments of the replay command.
+const ReplayUsage = "replay <from-id> [<to-id>] [<user>]"
+
+// replay sends notifications with IDs from from to to (inclusive) again through the messengers, to their current
+// subscribers or only to the given messenger account if it can view the parameter. If to is 0 notifications are replayed
+// up to the lastid cursor. The cursor is not touched. Returns the number of notifications replayed.
+func replay(ctx context.Context, ms []*messenger, from, to int, user string) (int, error) {
+	if to == 0 {
+		lastID, err := db.GetLastID(ctx)
+		if err != nil {
+			return 0, err
+		}
+		to = lastID
+	}
+	if from <= 0 || from > to {
+		return 0, fmt.Errorf("invalid replay range %d-%d", from, to)
+	}
+
+	notifier := newNotifiers(ms)
+	for _, ntf := range notifier {
+		ntf.replay = true
+		ntf.onlyUser = user
+	}
+	defer notifier.flush(ctx)
+
+	replayed := 0
+	for lastID := from - 1; lastID < to; {
+		notifications, err := getNotifications(ctx, lastID, config.GetInt("/onlineconf/batch-size", 100), 0)
+		if err != nil {
+			return replayed, err
+		}
+		if len(notifications.Notifications) == 0 {
+			break
+		}
+
+		for i := range notifications.Notifications {
+			notification := &notifications.Notifications[i]
+			if notification.ID > to {
+				return replayed, nil
+			}
+			if err := notifier.notify(ctx, notification); err != nil {
+				return replayed, err
+			}
+			lastID = notification.ID
+			replayed++
+		}
+	}
+
+	return replayed, nil
+}
+
+// parseReplayArgs parses "<from> [to] [user]", errors are replies to the requester.
+func parseReplayArgs(args []string) (from, to int, user string, err error) {
+	if len(args) < 1 || len(args) > 3 {
+		return 0, 0, "", errors.New("Usage: " + ReplayUsage)
+	}
+
+	from, err = strconv.Atoi(args[0])
+	if err != nil || from <= 0 {
+		return 0, 0, "", errors.New("Invalid notification id: " + args[0])
+	}
+
+	if len(args) > 1 {
+		if to, err = strconv.Atoi(args[1]); err != nil {
+			if len(args) > 2 {
+				return 0, 0, "", errors.New("Usage: " + ReplayUsage)
+			}
+			to, user = 0, args[1]
+		} else if len(args) > 2 {
+			user = args[2]
+		}
+	}
+	if to != 0 && to < from {
+		return 0, 0, "", errors.New("Invalid replay range")
+	}
+	return from, to, user, nil
+}
+
+// ReplayCommand handles the admin command replaying notifications. Replay runs in the background,
+// the requester gets a message when it is finished. Returns a plain text reply.
+// Without a user notifications are replayed through all messengers of the process, otherwise through the bot only.
+// Bots must check the requester is an admin before calling it.
+func ReplayCommand(ctx context.Context, bot Bot, requester string, args ...string) string {
+	from, to, user, err := parseReplayArgs(args)
+	if err != nil {
+		return err.Error()
+	}
+
+	m := messengerOf(bot)
+	ms := messengers
+	if user != "" || len(ms) == 0 {
+		ms = []*messenger{m}
+	}
+
+	go func() {
+		n, err := replay(ctx, ms, from, to, user)
+		text := fmt.Sprintf("Replay finished, %d notifications replayed", n)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Ctx(ctx).Error().Err(err).Int("from", from).Int("to", to).Msg("replay failed")
+			text = fmt.Sprintf("Replay failed after %d notifications: %s", n, err)
+		}
+		if err := m.bot.Notify(ctx, requester, &Message{Text: text, Style: StylePlain}); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to report replay result")
+		}
+	}()
+
+	return "Replay started"
+}
@@ -0,0 +1,46 @@
+package onlineconfbot
+
+import (
+	"testing"
+)
+
+func TestParseReplayArgs(t *testing.T) {
+	usage := "Usage: " + ReplayUsage
+	tests := []struct {
+		args     []string
+		wantFrom int
+		wantTo   int
+		wantUser string
+		wantErr  string
+	}{
+		{args: nil, wantErr: usage},
+		{args: []string{"10"}, wantFrom: 10},
+		{args: []string{"10", "20"}, wantFrom: 10, wantTo: 20},
+		{args: []string{"10", "alice"}, wantFrom: 10, wantUser: "alice"},
+		{args: []string{"10", "20", "alice"}, wantFrom: 10, wantTo: 20, wantUser: "alice"},
+		{args: []string{"10", "alice", "bob"}, wantErr: usage},
+		{args: []string{"10", "20", "alice", "bob"}, wantErr: usage},
+		{args: []string{"x"}, wantErr: "Invalid notification id: x"},
+		{args: []string{"0"}, wantErr: "Invalid notification id: 0"},
+		{args: []string{"-1"}, wantErr: "Invalid notification id: -1"},
+		{args: []string{"10", "5"}, wantErr: "Invalid replay range"},
+		{args: []string{"10", "10"}, wantFrom: 10, wantTo: 10},
+	}
+
+	for _, tt := range tests {
+		from, to, user, err := parseReplayArgs(tt.args)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseReplayArgs(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseReplayArgs(%q) unexpected error: %v", tt.args, err)
+			continue
+		}
+		if from != tt.wantFrom || to != tt.wantTo || user != tt.wantUser {
+			t.Errorf("parseReplayArgs(%q) = %d, %d, %q, want %d, %d, %q", tt.args, from, to, user, tt.wantFrom, tt.wantTo, tt.wantUser)
+		}
+	}
+}
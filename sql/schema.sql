@@ -7,7 +7,44 @@ CREATE TABLE `lastid` (
 INSERT INTO `lastid` VALUES (0, 0);
 
 CREATE TABLE `subscribe` (
+	`Messenger` varchar(32) NOT NULL DEFAULT '',
 	`User` varchar(128) NOT NULL,
 	`WO` tinyint(1) NOT NULL DEFAULT '1',
-	PRIMARY KEY (`User`)
+	PRIMARY KEY (`Messenger`, `User`)
+);
+
+CREATE TABLE `outbox` (
+	`ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+	`Messenger` varchar(32) NOT NULL DEFAULT '',
+	`User` varchar(128) NOT NULL,
+	`Origin` varchar(128) NOT NULL DEFAULT '',
+	`Style` varchar(16) NOT NULL,
+	`Label` varchar(64) NOT NULL DEFAULT '',
+	`Notifications` mediumtext NOT NULL,
+	`Attempts` int(11) NOT NULL DEFAULT '0',
+	`NextAttempt` datetime NOT NULL,
+	`State` enum('pending','dead','fallback') NOT NULL DEFAULT 'pending',
+	`Error` text NOT NULL,
+	`Fallback` varchar(160) NOT NULL DEFAULT '',
+	`Created` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (`ID`),
+	KEY `Messenger_State_NextAttempt` (`Messenger`, `State`, `NextAttempt`),
+	KEY `Messenger_User_State` (`Messenger`, `User`, `State`)
+);
+
+CREATE TABLE `sent` (
+	`NotificationID` bigint(20) unsigned NOT NULL,
+	`Messenger` varchar(32) NOT NULL DEFAULT '',
+	`User` varchar(128) NOT NULL,
+	`Created` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (`NotificationID`, `Messenger`, `User`),
+	KEY `Created` (`Created`)
+);
+
+CREATE TABLE `account` (
+	`Messenger` varchar(32) NOT NULL DEFAULT '',
+	`User` varchar(128) NOT NULL,
+	`Address` varchar(255) NOT NULL,
+	PRIMARY KEY (`Messenger`, `User`),
+	UNIQUE KEY `Messenger_Address` (`Messenger`, `Address`)
 );
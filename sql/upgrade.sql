@@ -1,2 +1,78 @@
 ALTER TABLE myteam_lastid RENAME TO lastid;
 ALTER TABLE myteam_subscribe RENAME TO subscribe;
+
+-- outbox of failed deliveries
+
+CREATE TABLE `outbox` (
+	`ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+	`NotificationID` bigint(20) unsigned NOT NULL,
+	`User` varchar(128) NOT NULL,
+	`Style` varchar(16) NOT NULL,
+	`Notifications` mediumtext NOT NULL,
+	`Attempts` int(11) NOT NULL DEFAULT '0',
+	`NextAttempt` datetime NOT NULL,
+	`State` enum('pending','dead') NOT NULL DEFAULT 'pending',
+	`Error` text NOT NULL,
+	`Created` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (`ID`),
+	KEY `State_NextAttempt` (`State`, `NextAttempt`)
+);
+
+-- sent-log
+
+CREATE TABLE `sent` (
+	`NotificationID` bigint(20) unsigned NOT NULL,
+	`User` varchar(128) NOT NULL,
+	`Created` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (`NotificationID`, `User`),
+	KEY `Created` (`Created`)
+);
+
+-- several messengers in one process
+
+ALTER TABLE `subscribe`
+	ADD `Messenger` varchar(32) NOT NULL DEFAULT '' FIRST,
+	DROP PRIMARY KEY,
+	ADD PRIMARY KEY (`Messenger`, `User`);
+
+ALTER TABLE `outbox`
+	ADD `Messenger` varchar(32) NOT NULL DEFAULT '' AFTER `ID`,
+	DROP KEY `State_NextAttempt`,
+	ADD KEY `Messenger_State_NextAttempt` (`Messenger`, `State`, `NextAttempt`);
+
+ALTER TABLE `sent`
+	ADD `Messenger` varchar(32) NOT NULL DEFAULT '' AFTER `NotificationID`,
+	DROP PRIMARY KEY,
+	ADD PRIMARY KEY (`NotificationID`, `Messenger`, `User`);
+
+-- fallback messenger
+
+ALTER TABLE `outbox`
+	ADD `Origin` varchar(128) NOT NULL DEFAULT '' AFTER `User`,
+	MODIFY `State` enum('pending','dead','fallback') NOT NULL DEFAULT 'pending',
+	ADD `Fallback` varchar(160) NOT NULL DEFAULT '' AFTER `Error`;
+
+-- linked messenger accounts
+
+CREATE TABLE `account` (
+	`Messenger` varchar(32) NOT NULL DEFAULT '',
+	`User` varchar(128) NOT NULL,
+	`Address` varchar(255) NOT NULL,
+	PRIMARY KEY (`Messenger`, `User`),
+	UNIQUE KEY `Messenger_Address` (`Messenger`, `Address`)
+);
+
+-- every delivery is recorded in the outbox before it is sent
+
+ALTER TABLE `outbox`
+	DROP `NotificationID`;
+
+-- in-order delivery to each user
+
+ALTER TABLE `outbox`
+	ADD KEY `Messenger_User_State` (`Messenger`, `User`, `State`);
+
+-- labels of backfilled deliveries are kept on retries
+
+ALTER TABLE `outbox`
+	ADD `Label` varchar(64) NOT NULL DEFAULT '' AFTER `Style`;